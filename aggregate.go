@@ -0,0 +1,108 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Aggregate is the client-side aggregate of every metric sent with the same
+// name and tags.
+type Aggregate struct {
+	Name string
+	Tags []string // "key:value" or "key", sorted
+	Type MetricType
+
+	// Value is the last value of a gauge and the total of a counter or of
+	// the timings, in the TimingUnit of the client.
+	Value float64
+	// Count is the number of timings.
+	Count uint64
+}
+
+// Aggregator keeps running aggregates of the metrics sent by the clients it
+// is attached to with the AggregateTo option. Aggregates are kept until
+// Reset, so its size grows with the number of distinct names and tag sets.
+type Aggregator struct {
+	mu   sync.Mutex
+	aggs map[string]*Aggregate
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{aggs: make(map[string]*Aggregate)}
+}
+
+// Snapshot returns a copy of all aggregates sorted by name.
+func (a *Aggregator) Snapshot() []Aggregate {
+	a.mu.Lock()
+	aggs := make([]Aggregate, 0, len(a.aggs))
+	for _, agg := range a.aggs {
+		aggs = append(aggs, *agg)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(aggs, func(i, j int) bool {
+		if aggs[i].Name != aggs[j].Name {
+			return aggs[i].Name < aggs[j].Name
+		}
+		return strings.Join(aggs[i].Tags, ",") < strings.Join(aggs[j].Tags, ",")
+	})
+	return aggs
+}
+
+// Reset drops all aggregates. Counters and timing totals start again from
+// zero, which Prometheus scraping statsdprom.Handler sees as a counter
+// reset.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	a.aggs = make(map[string]*Aggregate)
+	a.mu.Unlock()
+}
+
+// add aggregates m.
+func (a *Aggregator) add(m *Metric) {
+	val, err := strconv.ParseFloat(string(m.Value), 64)
+	if err != nil {
+		return
+	}
+
+	var tags []string
+	if len(m.Tags) > 0 {
+		tags = make([]string, len(m.Tags))
+		for i, t := range m.Tags {
+			tags[i] = t.Key
+			if t.Str != "" {
+				tags[i] += ":" + t.Str
+			}
+		}
+		sort.Strings(tags)
+	}
+
+	name := string(m.Name)
+	key := name + "|" + strings.Join(tags, ",")
+	a.mu.Lock()
+	agg, ok := a.aggs[key]
+	if !ok {
+		agg = &Aggregate{Name: name, Tags: tags, Type: m.Type}
+		a.aggs[key] = agg
+	} else if agg.Type != m.Type {
+		a.mu.Unlock()
+		return
+	}
+	rate := 1.0
+	if m.Rate > 0 {
+		rate = m.Rate
+	}
+	switch m.Type {
+	case MetricTypeGauge:
+		agg.Value = val
+	case MetricTypeCount:
+		agg.Value += val / rate
+	case MetricTypeTiming:
+		agg.Value += val / rate
+		agg.Count += uint64(math.Round(1 / rate))
+	}
+	a.mu.Unlock()
+}
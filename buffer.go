@@ -1,12 +1,21 @@
 package statsd
 
 import (
+	"bytes"
 	"strconv"
 	"sync"
 )
 
 type buf struct {
 	bs []byte
+
+	// m is the metric being encoded, its name and value live in scratch.
+	// See encode.
+	m       Metric
+	scratch []byte
+
+	prio MetricPriority
+	prec int // decimals of floats, -1 for full precision
 }
 
 func (b *buf) Bytes() []byte {
@@ -29,8 +38,32 @@ func (b *buf) AppendString(s string) {
 	b.bs = append(b.bs, s...)
 }
 
+func (b *buf) AppendBool(v bool) {
+	b.bs = strconv.AppendBool(b.bs, v)
+}
+
 func (b *buf) AppendFloat(f float64, bitSize int) {
-	b.bs = strconv.AppendFloat(b.bs, f, 'f', -1, bitSize)
+	b.bs = appendFloat(b.bs, f, b.prec, bitSize)
+}
+
+// appendFloat appends f rounded to prec decimals with trailing zeros
+// removed, or with full precision if prec is negative.
+func appendFloat(dst []byte, f float64, prec, bitSize int) []byte {
+	if prec < 0 {
+		return strconv.AppendFloat(dst, f, 'f', -1, bitSize)
+	}
+	start := len(dst)
+	dst = strconv.AppendFloat(dst, f, 'f', prec, bitSize)
+	if prec > 0 {
+		dst = bytes.TrimRight(dst, "0")
+		if dst[len(dst)-1] == '.' {
+			dst = dst[:len(dst)-1]
+		}
+	}
+	if s := dst[start:]; string(s) == "-0" {
+		dst = append(dst[:start], '0')
+	}
+	return dst
 }
 
 func (b *buf) AppendFloat32(v float32) { b.AppendFloat(float64(v), 32) }
@@ -44,7 +77,7 @@ func (b *buf) AppendUint32(v uint32)   { b.AppendUint64(uint64(v)) }
 
 var bufPool = sync.Pool{
 	New: func() interface{} {
-		return &buf{bs: make([]byte, 0, 512)}
+		return &buf{bs: make([]byte, 0, 512), scratch: make([]byte, 0, 256), prio: PriorityNormal, prec: -1}
 	},
 }
 
@@ -54,5 +87,8 @@ func getBuf() *buf {
 
 func freeBuf(b *buf) {
 	b.bs = b.bs[:0]
+	b.scratch = b.scratch[:0]
+	b.m = Metric{Tags: b.m.Tags[:0]}
+	b.prio = PriorityNormal
 	bufPool.Put(b)
 }
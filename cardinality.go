@@ -0,0 +1,122 @@
+package statsd
+
+import (
+	"errors"
+	"hash/maphash"
+	"sync"
+)
+
+// CardinalityConfig configures the cardinality guard, see CardinalityLimit.
+type CardinalityConfig struct {
+	// Limit is the number of distinct names and tag sets allowed per
+	// prefix.
+	Limit int
+	// Depth is the number of leading bucket segments forming the prefix,
+	// not counting the client prefix and hostname. Defaults to 1.
+	Depth int
+	// Fallback is the bucket overflowing metrics are sent to instead,
+	// without their tags except the client's. If empty, they are dropped
+	// and reported to the ErrorHandler.
+	Fallback string
+	// MaxPrefixes caps the number of prefixes tracked, each taking up to
+	// about 16 bytes per name. Metrics of prefixes beyond it overflow.
+	// Defaults to 1000.
+	MaxPrefixes int
+}
+
+// CardinalityLimit guards against names and tags made of unbounded values,
+// e.g. user IDs. The first cfg.Limit distinct names and tag sets of each
+// prefix are remembered by their 64-bit hash, and further ones are rewritten
+// to cfg.Fallback or dropped.
+func CardinalityLimit(cfg CardinalityConfig) Option {
+	return func(o *options) {
+		o.cardinality = &cfg
+	}
+}
+
+var errCardinality = errors.New("statsd: cardinality limit exceeded")
+
+type cardinalityGuard struct {
+	cfg  CardinalityConfig
+	seed maphash.Seed
+
+	mu       sync.Mutex
+	prefixes map[string]map[uint64]struct{} // hashes of the allowed names
+}
+
+func newCardinalityGuard(cfg CardinalityConfig) *cardinalityGuard {
+	if cfg.Depth <= 0 {
+		cfg.Depth = 1
+	}
+	if cfg.MaxPrefixes <= 0 {
+		cfg.MaxPrefixes = 1000
+	}
+	return &cardinalityGuard{cfg: cfg, seed: maphash.MakeSeed(), prefixes: make(map[string]map[uint64]struct{})}
+}
+
+// allow reports whether m, whose bucket starts at name offset skip, is
+// within the limit of its prefix.
+func (g *cardinalityGuard) allow(m *Metric, skip int) bool {
+	prefix := m.Name[skip:]
+	for i, n := 0, 0; i < len(prefix); i++ {
+		if prefix[i] == '.' {
+			if n++; n == g.cfg.Depth {
+				prefix = prefix[:i]
+				break
+			}
+		}
+	}
+
+	var h maphash.Hash
+	h.SetSeed(g.seed)
+	h.Write(m.Name)
+	for i := range m.Tags {
+		h.WriteByte(0)
+		h.WriteString(m.Tags[i].Key)
+		h.WriteByte(0)
+		h.WriteString(m.Tags[i].Str)
+	}
+	x := h.Sum64()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seen, ok := g.prefixes[string(prefix)]
+	if !ok {
+		if len(g.prefixes) >= g.cfg.MaxPrefixes {
+			return false
+		}
+		seen = make(map[uint64]struct{})
+		g.prefixes[string(prefix)] = seen
+	}
+	if _, ok := seen[x]; ok {
+		return true
+	}
+	if len(seen) >= g.cfg.Limit {
+		return false
+	}
+	seen[x] = struct{}{}
+	return true
+}
+
+// overflow rewrites the name of m to the fallback bucket, keeping the first
+// skip bytes, and drops the tags after the first keep.
+func (g *cardinalityGuard) overflow(b *buf, skip int, keep int) {
+	start := len(b.scratch)
+	b.scratch = append(b.scratch, b.m.Name[:skip]...)
+	b.scratch = append(b.scratch, g.cfg.Fallback...)
+	b.m.Name = b.scratch[start:]
+	b.m.Tags = b.m.Tags[:keep]
+}
+
+// bucketOffset returns the length of the client prefix and hostname at the
+// start of encoded names.
+func bucketOffset(prefix, hostname string) int {
+	n := 0
+	if prefix != "" {
+		n += len(prefix) + 1
+	}
+	if hostname != "" {
+		n += len(hostname) + 1
+	}
+	return n
+}
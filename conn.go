@@ -1,8 +1,13 @@
 package statsd
 
 import (
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,67 +15,289 @@ var (
 	dialTimeout = net.DialTimeout
 )
 
+var errTLSNetwork = errors.New("statsd: TLS requires a stream network")
+
+// A failed dial is retried after a backoff doubling from minDialBackoff up
+// to maxDialBackoff.
+const (
+	minDialBackoff = 100 * time.Millisecond
+	maxDialBackoff = 5 * time.Second
+)
+
 type clientConn struct {
 	network, addr string
 	c             *Client
-	conn          net.Conn
+	stream        bool
+	done          chan struct{}
+	kick          chan struct{} // a packet is ready to be flushed
 
-	mu  sync.Mutex
-	buf []byte
+	mu      sync.Mutex
+	lanes   [numPriorities][]byte
+	queued  int
+	closed  bool
+	dropped [numPriorities]atomic.Uint64
+
+	// ioMu guards the fields used to send packets, so writers don't wait
+	// for the network.
+	ioMu     sync.Mutex
+	conn     net.Conn // nil after a stream broke, until redialed by flush
+	spool    *spool
+	packet   []byte
+	shut     bool
+	backoff  time.Duration
+	nextDial time.Time
 }
 
+// newClientConn dials addr. With a spool, a failed dial is reported to the
+// ErrorHandler instead, and metrics are spooled until a redial succeeds.
 func newClientConn(network, addr string, c *Client) (*clientConn, error) {
-	conn, err := dialTimeout(network, addr, c.opts.timeout)
-	if err != nil {
-		return nil, err
-	}
-
 	cc := &clientConn{
 		network: network,
 		addr:    addr,
 		c:       c,
-		conn:    conn,
-		buf:     make([]byte, 0, c.opts.maxPacketSize),
+		stream:  isStream(network),
+		done:    make(chan struct{}),
+		kick:    make(chan struct{}, 1),
+		packet:  make([]byte, 0, c.opts.maxPacketSize),
+	}
+	var err error
+	if c.opts.spool != nil && cc.stream {
+		if cc.spool, err = openSpool(*c.opts.spool); err != nil {
+			return nil, err
+		}
 	}
 
-	go func() {
-		ticker := time.NewTicker(c.opts.flushPeriod)
-		for _ = range ticker.C {
-			cc.mu.Lock()
-			cc.flush()
-			cc.mu.Unlock()
+	if cc.conn, err = dial(network, addr, &c.opts); err != nil {
+		if cc.spool == nil {
+			return nil, err
 		}
-	}()
+		c.handleError(err)
+		cc.dialFailed()
+	}
+
+	go cc.flushLoop(c.opts.flushPeriod)
 
 	return cc, nil
 }
 
-func (cc *clientConn) write(b []byte) {
+func (cc *clientConn) flushLoop(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cc.c.flushSummaries()
+			cc.flush(true)
+		case <-cc.kick:
+			cc.flush(false)
+		case <-cc.done:
+			return
+		}
+	}
+}
+
+// write queues b in the lane of priority p. When the queue is full, lower
+// priorities are shed first, and b is dropped if that doesn't make room.
+func (cc *clientConn) write(b []byte, p MetricPriority) {
 	cc.mu.Lock()
-	if len(cc.buf)+len(b) > cap(cc.buf) {
-		cc.flush()
+	if cc.closed {
+		cc.mu.Unlock()
+		return
+	}
+	if cc.queued+len(b) > cc.c.opts.queueSize {
+		cc.shed(p, len(b))
+		if cc.queued+len(b) > cc.c.opts.queueSize {
+			cc.dropped[p].Add(uint64(bytes.Count(b, newline)))
+			cc.mu.Unlock()
+			return
+		}
 	}
-	cc.buf = append(cc.buf, b...)
+	cc.lanes[p] = append(cc.lanes[p], b...)
+	cc.queued += len(b)
+	full := cc.queued >= cc.c.opts.maxPacketSize
 	cc.mu.Unlock()
+
+	if full {
+		select {
+		case cc.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+var newline = []byte{'\n'}
+
+// shed empties the lanes below priority p, lowest first, until n bytes fit.
+func (cc *clientConn) shed(p MetricPriority, n int) {
+	for q := PriorityLow; q < p && cc.queued+n > cc.c.opts.queueSize; q++ {
+		cc.dropped[q].Add(uint64(bytes.Count(cc.lanes[q], newline)))
+		cc.queued -= len(cc.lanes[q])
+		cc.lanes[q] = cc.lanes[q][:0]
+	}
+}
+
+// takePacket moves up to a packet of lines from the lanes to dst, highest
+// priority first.
+func (cc *clientConn) takePacket(dst []byte) []byte {
+	for p := numPriorities - 1; p >= 0; p-- {
+		lane := cc.lanes[p]
+		for len(lane) > 0 {
+			n := bytes.IndexByte(lane, '\n') + 1
+			if n == 0 {
+				n = len(lane)
+			}
+			if len(dst) > 0 && len(dst)+n > cc.c.opts.maxPacketSize {
+				break
+			}
+			dst = append(dst, lane[:n]...)
+			lane = lane[n:]
+		}
+		cc.queued -= len(cc.lanes[p]) - len(lane)
+		cc.lanes[p] = cc.lanes[p][:copy(cc.lanes[p], lane)]
+		if len(lane) > 0 {
+			break
+		}
+	}
+	return dst
+}
+
+// flush sends the queued metrics packet by packet, leaving a partial
+// packet queued unless all.
+func (cc *clientConn) flush(all bool) {
+	cc.ioMu.Lock()
+	defer cc.ioMu.Unlock()
+	for !cc.shut {
+		cc.mu.Lock()
+		if !all && cc.queued < cc.c.opts.maxPacketSize {
+			cc.mu.Unlock()
+			return
+		}
+		cc.packet = cc.takePacket(cc.packet[:0])
+		cc.mu.Unlock()
+		if len(cc.packet) == 0 && !cc.spool.pending() {
+			return
+		}
+		cc.writePacket()
+		if len(cc.packet) == 0 {
+			return
+		}
+	}
 }
 
-func (cc *clientConn) flush() {
-	if len(cc.buf) == 0 {
+// writePacket writes the packet, redialing a broken stream and replaying
+// the spool first. On error the packet is spooled or dropped, and a stream
+// is closed to be redialed on the next flush. Until the backoff of a failed
+// dial has passed, packets are spooled or dropped without dialing.
+func (cc *clientConn) writePacket() {
+	if cc.conn == nil {
+		if time.Now().Before(cc.nextDial) {
+			cc.spoolPacket()
+			return
+		}
+		conn, err := dial(cc.network, cc.addr, &cc.c.opts)
+		if err != nil {
+			cc.c.handleError(err)
+			cc.dialFailed()
+			cc.spoolPacket()
+			return
+		}
+		cc.conn = conn
+		cc.backoff = 0
+	}
+
+	if err := cc.spool.replay(cc.writeConn); err != nil {
+		cc.broken(err)
+		cc.spoolPacket()
 		return
 	}
-	_, err := cc.conn.Write(cc.buf[:len(cc.buf)])
-	cc.handleError(err)
-	cc.buf = cc.buf[:0]
+	if len(cc.packet) == 0 {
+		return
+	}
+	if err := cc.writeConn(cc.packet); err != nil {
+		cc.broken(err)
+		cc.spoolPacket()
+	}
+}
+
+// dialFailed doubles the backoff before the next dial.
+func (cc *clientConn) dialFailed() {
+	cc.backoff = min(max(2*cc.backoff, minDialBackoff), maxDialBackoff)
+	cc.nextDial = time.Now().Add(cc.backoff)
+}
+
+func (cc *clientConn) writeConn(b []byte) error {
+	if cc.stream {
+		cc.conn.SetWriteDeadline(time.Now().Add(cc.c.opts.timeout))
+	}
+	_, err := cc.conn.Write(b)
+	return err
+}
+
+// broken reports err and closes a stream, so it is redialed.
+func (cc *clientConn) broken(err error) {
+	cc.c.handleError(err)
+	if cc.stream {
+		cc.conn.Close()
+		cc.conn = nil
+	}
 }
 
-func (cc *clientConn) handleError(err error) {
-	if err == nil {
+func (cc *clientConn) spoolPacket() {
+	if cc.spool == nil || len(cc.packet) == 0 {
 		return
 	}
+	if err := cc.spool.write(cc.packet); err != nil {
+		cc.c.handleError(err)
+	}
+}
 
-	if cc.c.opts.errHandler != nil {
-		cc.c.opts.errHandler(err)
+func (cc *clientConn) close() error {
+	cc.mu.Lock()
+	if cc.closed {
+		cc.mu.Unlock()
+		return nil
 	}
+	cc.closed = true
+	close(cc.done)
+	cc.mu.Unlock()
+
+	cc.flush(true)
 
-	// TODO: reconnect if network net is a stream-orientend network: "tcp", "tcp4"
+	cc.ioMu.Lock()
+	defer cc.ioMu.Unlock()
+	cc.shut = true
+	if err := cc.spool.close(); err != nil {
+		cc.c.handleError(err)
+	}
+	if cc.conn == nil {
+		return nil
+	}
+	err := cc.conn.Close()
+	cc.conn = nil
+	return err
+}
+
+// dial connects to addr. A network suffixed with "+tls", e.g. "tcp+tls", or
+// a TLSConfig option make it a TLS connection.
+func dial(network, addr string, opts *options) (net.Conn, error) {
+	network, useTLS := strings.CutSuffix(network, "+tls")
+	if !useTLS && opts.tlsConfig == nil {
+		return dialTimeout(network, addr, opts.timeout)
+	}
+	if !isStream(network) {
+		return nil, errTLSNetwork
+	}
+	config := opts.tlsConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: opts.timeout}, network, addr, config)
+}
+
+func isStream(network string) bool {
+	switch strings.TrimSuffix(network, "+tls") {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
 }
@@ -0,0 +1,106 @@
+package statsd
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey struct{}
+
+type contextFields struct {
+	prefix []Field
+	tags   []Field
+}
+
+// ContextWithTags returns a copy of ctx carrying tags in addition to those
+// it already carries. The Ctx methods send them along with the client tags.
+func ContextWithTags(ctx context.Context, tags ...Field) context.Context {
+	cf := fieldsFromContext(ctx)
+	cf.tags = append(cf.tags[:len(cf.tags):len(cf.tags)], tags...)
+	return context.WithValue(ctx, contextKey{}, cf)
+}
+
+// ContextWithPrefix returns a copy of ctx carrying bucket fields which the
+// Ctx methods put in front of the bucket, after those ctx already carries.
+func ContextWithPrefix(ctx context.Context, prefix ...Field) context.Context {
+	cf := fieldsFromContext(ctx)
+	cf.prefix = append(cf.prefix[:len(cf.prefix):len(cf.prefix)], prefix...)
+	return context.WithValue(ctx, contextKey{}, cf)
+}
+
+// TagsFromContext returns the tags carried by ctx.
+func TagsFromContext(ctx context.Context) []Field {
+	return fieldsFromContext(ctx).tags
+}
+
+func fieldsFromContext(ctx context.Context) contextFields {
+	cf, _ := ctx.Value(contextKey{}).(contextFields)
+	return cf
+}
+
+// withContext returns bucket preceded by the prefix and tags carried by ctx.
+func (c *Client) withContext(ctx context.Context, bucket []Field) []Field {
+	if c.discard() {
+		return bucket
+	}
+	cf := fieldsFromContext(ctx)
+	if len(cf.prefix) == 0 && len(cf.tags) == 0 {
+		return bucket
+	}
+	merged := make([]Field, 0, len(cf.prefix)+len(cf.tags)+len(bucket))
+	merged = append(merged, cf.prefix...)
+	merged = append(merged, cf.tags...)
+	return append(merged, bucket...)
+}
+
+func (c *Client) IncrementCtx(ctx context.Context, bucket ...Field) {
+	c.Increment(c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) CountInt32Ctx(ctx context.Context, n int32, bucket ...Field) {
+	c.CountInt32(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) CountUint32Ctx(ctx context.Context, n uint32, bucket ...Field) {
+	c.CountUint32(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) CountInt64Ctx(ctx context.Context, n int64, bucket ...Field) {
+	c.CountInt64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) CountUint64Ctx(ctx context.Context, n uint64, bucket ...Field) {
+	c.CountUint64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) CountFloat64Ctx(ctx context.Context, n float64, bucket ...Field) {
+	c.CountFloat64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) GaugeInt32Ctx(ctx context.Context, n int32, bucket ...Field) {
+	c.GaugeInt32(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) GaugeUint32Ctx(ctx context.Context, n uint32, bucket ...Field) {
+	c.GaugeUint32(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) GaugeInt64Ctx(ctx context.Context, n int64, bucket ...Field) {
+	c.GaugeInt64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) GaugeUint64Ctx(ctx context.Context, n uint64, bucket ...Field) {
+	c.GaugeUint64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) GaugeFloat64Ctx(ctx context.Context, n float64, bucket ...Field) {
+	c.GaugeFloat64(n, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) TimingSinceCtx(ctx context.Context, start time.Time, bucket ...Field) {
+	c.TimingSince(start, c.withContext(ctx, bucket)...)
+}
+
+func (c *Client) TimingCtx(ctx context.Context, duration time.Duration, bucket ...Field) {
+	c.Timing(duration, c.withContext(ctx, bucket)...)
+}
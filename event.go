@@ -0,0 +1,126 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+type EventPriority string
+
+const (
+	EventPriorityNormal EventPriority = "normal"
+	EventPriorityLow    EventPriority = "low"
+)
+
+type EventAlertType string
+
+const (
+	EventAlertTypeInfo    EventAlertType = "info"
+	EventAlertTypeWarning EventAlertType = "warning"
+	EventAlertTypeError   EventAlertType = "error"
+	EventAlertTypeSuccess EventAlertType = "success"
+)
+
+// Event is a DogStatsD event. Only Title is required.
+type Event struct {
+	Title          string
+	Text           string
+	Timestamp      time.Time
+	Hostname       string
+	AggregationKey string
+	Priority       EventPriority
+	SourceTypeName string
+	AlertType      EventAlertType
+	Tags           []Field // see Tag
+}
+
+var errEventTitle = errors.New("statsd: event title is required")
+
+// Event sends e in the DogStatsD format
+//
+//	_e{<title length>,<text length>}:<title>|<text>|d:<timestamp>|h:<hostname>|k:<aggregation key>|p:<priority>|s:<source type>|t:<alert type>|#<tags>
+//
+// along with the client tags. Newlines in title and text are escaped as
+// "\n". Invalid events are reported to the ErrorHandler.
+func (c *Client) Event(e *Event) {
+	if c.cc == nil {
+		return
+	}
+	if e.Title == "" {
+		c.handleError(errEventTitle)
+		return
+	}
+	b := encodeEvent(e, c.opts.tags)
+	c.cc.write(b.Bytes(), c.opts.priority)
+	freeBuf(b)
+}
+
+func encodeEvent(e *Event, tags []Field) *buf {
+	b := getBuf()
+	b.AppendString("_e{")
+	b.AppendInt64(int64(escapedLen(e.Title)))
+	b.AppendString(",")
+	b.AppendInt64(int64(escapedLen(e.Text)))
+	b.AppendString("}:")
+	appendEscaped(b, e.Title)
+	b.AppendString("|")
+	appendEscaped(b, e.Text)
+
+	if !e.Timestamp.IsZero() {
+		b.AppendString("|d:")
+		b.AppendInt64(e.Timestamp.Unix())
+	}
+	appendOptional(b, "|h:", e.Hostname)
+	appendOptional(b, "|k:", e.AggregationKey)
+	appendOptional(b, "|p:", string(e.Priority))
+	appendOptional(b, "|s:", e.SourceTypeName)
+	appendOptional(b, "|t:", string(e.AlertType))
+	appendTags(b, tags, e.Tags)
+	b.AppendString("\n")
+	return b
+}
+
+func appendOptional(b *buf, key, value string) {
+	if value != "" {
+		b.AppendString(key)
+		b.AppendString(value)
+	}
+}
+
+func escapedLen(s string) int {
+	return len(s) + strings.Count(s, "\n")
+}
+
+func appendEscaped(b *buf, s string) {
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			b.AppendString(s)
+			return
+		}
+		b.AppendString(s[:i])
+		b.AppendString("\\n")
+		s = s[i+1:]
+	}
+}
+
+// appendTags appends the constant tags followed by the tag fields found in
+// bucket in the DogStatsD format, if any. Delimiters in them are replaced by
+// '_'.
+func appendTags(b *buf, tags []Field, bucket []Field) {
+	sep := "|#"
+	for i := range tags {
+		b.AppendString(sep)
+		tags[i].appendTo(b)
+		sep = ","
+	}
+	for i := range bucket {
+		if bucket[i].Type != FieldTypeTag {
+			continue
+		}
+		b.AppendString(sep)
+		bucket[i].appendTo(b)
+		sep = ","
+	}
+}
@@ -0,0 +1,188 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Metric is a metric as handed to a Formatter.
+type Metric struct {
+	Name  []byte
+	Value []byte
+	Type  MetricType
+	Tags  []Field // constant tags followed by the tag fields of the bucket
+
+	// Rate is the rate the metric was sampled at, 0 or 1 if it wasn't.
+	Rate float64
+
+	// Timestamp is the unix time of the metric, or 0. It is only set if the
+	// formatter supports timestamps for the metric type.
+	Timestamp int64
+}
+
+// Formatter encodes metrics on the wire.
+type Formatter interface {
+	// AppendMetric appends m, terminated by a newline, to dst and returns
+	// the extended buffer.
+	AppendMetric(dst []byte, m *Metric) []byte
+	// Timestamps reports whether metrics of type typ can carry a timestamp.
+	Timestamps(typ MetricType) bool
+}
+
+// The built-in wire formats. Except for DogStatsD, tags consisting of a key
+// only are dropped. Characters delimiting tags in a format are replaced by
+// '_' in tag keys and values: newlines, '|' and ',' in DogStatsD, and
+// newlines, ',', '=', ':', ';', '[' and ']' in the formats embedding tags in
+// the name.
+var (
+	// Etsy is the original statsd format "<name>:<value>|<type>". Tags are
+	// dropped.
+	Etsy Formatter = &lineFormatter{}
+
+	// DogStatsD is the default format
+	// "<name>:<value>|<type>|@<rate>|#<key>:<value>,...|T<timestamp>".
+	// Gauges and counts may carry a timestamp.
+	DogStatsD Formatter = &lineFormatter{
+		tagStart:   "|#",
+		tagSep:     ",",
+		tagKV:      ":",
+		reserved:   dogStatsDReserved,
+		bareTags:   true,
+		timestamps: true,
+	}
+
+	// InfluxDB is the format of the Telegraf statsd input
+	// "<name>,<key>=<value>,...:<value>|<type>".
+	InfluxDB Formatter = &lineFormatter{
+		tagStart:      ",",
+		tagSep:        ",",
+		tagKV:         "=",
+		reserved:      nameTagReserved,
+		tagsAfterName: true,
+	}
+
+	// GraphiteTags is the Graphite tagged series format
+	// "<name>;<key>=<value>;...:<value>|<type>".
+	GraphiteTags Formatter = &lineFormatter{
+		tagStart:      ";",
+		tagSep:        ";",
+		tagKV:         "=",
+		reserved:      nameTagReserved,
+		tagsAfterName: true,
+	}
+
+	// SignalFx is the dimension format of the SignalFx agent
+	// "<name>[<key>=<value>,...]:<value>|<type>".
+	SignalFx Formatter = &lineFormatter{
+		tagStart:      "[",
+		tagSep:        ",",
+		tagKV:         "=",
+		tagEnd:        "]",
+		reserved:      nameTagReserved,
+		tagsAfterName: true,
+	}
+)
+
+// WireFormat sets the format metrics are sent in, DogStatsD by default.
+// Events and service checks are always sent in the DogStatsD format.
+func WireFormat(f Formatter) Option {
+	return func(o *options) {
+		o.formatter = f
+	}
+}
+
+const (
+	dogStatsDReserved = "\n|,"
+	nameTagReserved   = "\n,=:;[]"
+)
+
+// lineFormatter writes tags as <start><key><kv><value><sep>...<end>, either
+// right after the name or after the type. An empty tagStart drops tags.
+type lineFormatter struct {
+	tagStart, tagSep, tagKV, tagEnd string
+	reserved                        string // replaced by '_' in tags
+	tagsAfterName                   bool
+	bareTags                        bool // write tags without value as key, else drop them
+	timestamps                      bool
+}
+
+func (f *lineFormatter) Timestamps(typ MetricType) bool {
+	return f.timestamps && typ != MetricTypeTiming
+}
+
+func (f *lineFormatter) AppendMetric(dst []byte, m *Metric) []byte {
+	dst = append(dst, m.Name...)
+	if f.tagsAfterName {
+		dst = f.appendTags(dst, m.Tags)
+	}
+	dst = append(dst, ':')
+	dst = append(dst, m.Value...)
+	dst = append(dst, '|')
+	dst = appendType(dst, m.Type)
+	if m.Rate > 0 && m.Rate < 1 {
+		dst = append(dst, "|@"...)
+		dst = strconv.AppendFloat(dst, m.Rate, 'f', -1, 64)
+	}
+	if !f.tagsAfterName {
+		dst = f.appendTags(dst, m.Tags)
+	}
+	if m.Timestamp != 0 && f.Timestamps(m.Type) {
+		dst = append(dst, "|T"...)
+		dst = strconv.AppendInt(dst, m.Timestamp, 10)
+	}
+	return append(dst, '\n')
+}
+
+func (f *lineFormatter) appendTags(dst []byte, tags []Field) []byte {
+	if f.tagStart == "" {
+		return dst
+	}
+	n := 0
+	for i := range tags {
+		if tags[i].Str == "" && !f.bareTags {
+			continue
+		}
+		if n == 0 {
+			dst = append(dst, f.tagStart...)
+		} else {
+			dst = append(dst, f.tagSep...)
+		}
+		n++
+		dst = appendSanitized(dst, tags[i].Key, f.reserved)
+		if tags[i].Str != "" {
+			dst = append(dst, f.tagKV...)
+			dst = appendSanitized(dst, tags[i].Str, f.reserved)
+		}
+	}
+	if n > 0 {
+		dst = append(dst, f.tagEnd...)
+	}
+	return dst
+}
+
+// appendSanitized appends s with the bytes found in reserved replaced by '_'.
+func appendSanitized(dst []byte, s, reserved string) []byte {
+	if strings.IndexAny(s, reserved) < 0 {
+		return append(dst, s...)
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(reserved, s[i]) >= 0 {
+			dst = append(dst, '_')
+		} else {
+			dst = append(dst, s[i])
+		}
+	}
+	return dst
+}
+
+func appendType(dst []byte, typ MetricType) []byte {
+	switch typ {
+	case MetricTypeGauge:
+		return append(dst, 'g')
+	case MetricTypeCount:
+		return append(dst, 'c')
+	case MetricTypeTiming:
+		return append(dst, "ms"...)
+	}
+	panic("unknown metric type: " + strconv.Itoa(int(typ)))
+}
@@ -0,0 +1,164 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirk91/statsd/internal/poll"
+)
+
+// GaugeID identifies a gauge callback, see RegisterGauge.
+type GaugeID uint64
+
+// GaugeTimeout sets how long gauge callbacks may take, the flush period by
+// default.
+func GaugeTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.gaugeTimeout = d
+	}
+}
+
+var errGaugeTimeout = errors.New("statsd: gauge callback timed out")
+
+// RegisterGauge makes the client send the value returned by f as a gauge
+// every flush period until unregistered. The callbacks run concurrently,
+// one that panics or exceeds the GaugeTimeout is reported to the
+// ErrorHandler, and a callback still running from an earlier period is
+// skipped. Non-finite values are not sent. A client without a connection
+// only runs the callbacks if it has an Aggregator, see AggregateTo.
+func (c *Client) RegisterGauge(bucket []Field, f func() float64) GaugeID {
+	e := &gaugeEntry{c: c, bucket: append([]Field(nil), bucket...), f: f}
+	e.id = GaugeID(c.gauges.nextID.Add(1))
+	for {
+		old := c.gauges.entries.Load()
+		entries := make([]*gaugeEntry, 0, len(*old)+1)
+		entries = append(append(entries, *old...), e)
+		if c.gauges.entries.CompareAndSwap(old, &entries) {
+			c.gauges.start(c)
+			return e.id
+		}
+	}
+}
+
+// Unregister removes the gauge callback id.
+func (c *Client) Unregister(id GaugeID) {
+	for {
+		old := c.gauges.entries.Load()
+		entries := make([]*gaugeEntry, 0, len(*old))
+		for _, e := range *old {
+			if e.id != id {
+				entries = append(entries, e)
+			}
+		}
+		if c.gauges.entries.CompareAndSwap(old, &entries) {
+			return
+		}
+	}
+}
+
+// gaugeRegistry is copied on write, so the gauge loop reads it without
+// locking.
+type gaugeRegistry struct {
+	nextID  atomic.Uint64
+	entries atomic.Pointer[[]*gaugeEntry]
+
+	mu     sync.Mutex
+	loop   *poll.Loop // started by the first RegisterGauge
+	closed bool
+}
+
+type gaugeEntry struct {
+	id      GaugeID
+	c       *Client // the client registered with, for its priority
+	bucket  []Field
+	f       func() float64
+	running atomic.Bool
+}
+
+func newGaugeRegistry() *gaugeRegistry {
+	r := &gaugeRegistry{}
+	r.entries.Store(&[]*gaugeEntry{})
+	return r
+}
+
+type gaugeResult struct {
+	e   *gaugeEntry
+	v   float64
+	err error
+}
+
+// eval runs the callbacks and sends their values through c.
+// start runs the callbacks every flush period, apart from the flush loop so
+// slow callbacks don't hold up sending.
+func (r *gaugeRegistry) start(c *Client) {
+	if c.discard() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loop == nil && !r.closed {
+		r.loop = poll.Start(c.opts.flushPeriod, func() { r.eval(c) })
+	}
+}
+
+// stop stops the loop and waits for the callbacks in progress.
+func (r *gaugeRegistry) stop() {
+	r.mu.Lock()
+	r.closed = true
+	loop := r.loop
+	r.mu.Unlock()
+	if loop != nil {
+		loop.Stop()
+	}
+}
+
+func (r *gaugeRegistry) eval(c *Client) {
+	entries := *r.entries.Load()
+	if len(entries) == 0 {
+		return
+	}
+
+	results := make(chan gaugeResult, len(entries))
+	n := 0
+	for _, e := range entries {
+		if !e.running.CompareAndSwap(false, true) {
+			continue
+		}
+		n++
+		go e.run(results)
+	}
+
+	timer := time.NewTimer(c.opts.gaugeTimeout)
+	defer timer.Stop()
+	for ; n > 0; n-- {
+		select {
+		case res := <-results:
+			if res.err != nil {
+				c.handleError(res.err)
+			} else if !math.IsNaN(res.v) && !math.IsInf(res.v, 0) {
+				res.e.c.GaugeFloat64(res.v, res.e.bucket...)
+			}
+		case <-timer.C:
+			for ; n > 0; n-- {
+				c.handleError(errGaugeTimeout)
+			}
+			return
+		}
+	}
+}
+
+func (e *gaugeEntry) run(results chan<- gaugeResult) {
+	res := gaugeResult{e: e}
+	defer func() {
+		if r := recover(); r != nil {
+			res.err = fmt.Errorf("statsd: gauge callback panicked: %v", r)
+		}
+		e.running.Store(false)
+		results <- res
+	}()
+	res.v = e.f()
+}
@@ -0,0 +1,65 @@
+package statsd
+
+import "unsafe"
+
+// Number is any integer or floating point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Value returns a field holding n, e.g. to pass a metric value around.
+func Value[T Number](n T) Field {
+	half := T(1)
+	half /= 2
+	switch {
+	case half != 0 && unsafe.Sizeof(n) == 4:
+		return Float32(float32(n))
+	case half != 0:
+		return Float64(float64(n))
+	case T(0)-1 < 0:
+		return Int64(int64(n))
+	default:
+		return Uint64(uint64(n))
+	}
+}
+
+// Count sends n of any number type as a counter, e.g. Count(c, 0.5, ...).
+// The type-suffixed Count methods are shorthands for it. Go methods can't
+// have type parameters, so it takes a *Client and isn't available through
+// Statter or NoopClient.
+func Count[T Number](c *Client, n T, bucket ...Field) {
+	c.send(c.encode(MetricTypeCount, Value(n), bucket))
+}
+
+func Countf[T Number](c *Client, n T, template string, args ...interface{}) {
+	c.send(c.encodeTpl(MetricTypeCount, Value(n), template, args))
+}
+
+func CountWithHost[T Number](c *Client, n T, bucket ...Field) {
+	c.send(c.encodeWithHost(MetricTypeCount, Value(n), bucket))
+}
+
+func CountfWithHost[T Number](c *Client, n T, template string, args ...interface{}) {
+	c.send(c.encodeTplWithHost(MetricTypeCount, Value(n), template, args))
+}
+
+// Gauge sends n of any number type as a gauge, e.g. Gauge(c, len(queue),
+// ...). The type-suffixed Gauge methods are shorthands for it. Like Count,
+// it takes a *Client and isn't available through Statter or NoopClient.
+func Gauge[T Number](c *Client, n T, bucket ...Field) {
+	c.send(c.encode(MetricTypeGauge, Value(n), bucket))
+}
+
+func Gaugef[T Number](c *Client, n T, template string, args ...interface{}) {
+	c.send(c.encodeTpl(MetricTypeGauge, Value(n), template, args))
+}
+
+func GaugeWithHost[T Number](c *Client, n T, bucket ...Field) {
+	c.send(c.encodeWithHost(MetricTypeGauge, Value(n), bucket))
+}
+
+func GaugefWithHost[T Number](c *Client, n T, template string, args ...interface{}) {
+	c.send(c.encodeTplWithHost(MetricTypeGauge, Value(n), template, args))
+}
@@ -0,0 +1,46 @@
+// Package poll runs a function on an interval in the background, for the
+// packages reporting periodically.
+package poll
+
+import (
+	"sync"
+	"time"
+)
+
+// Loop calls a function on every tick until stopped.
+type Loop struct {
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Start calls f every interval in a new goroutine until Stop is called.
+func Start(interval time.Duration, f func()) *Loop {
+	l := &Loop{done: make(chan struct{})}
+	l.wg.Add(1)
+	go l.run(interval, f)
+	return l
+}
+
+func (l *Loop) run(interval time.Duration, f func()) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// Stop stops the loop and waits for an in-progress call to finish. It may
+// be called more than once.
+func (l *Loop) Stop() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	l.wg.Wait()
+}
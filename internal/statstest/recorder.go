@@ -0,0 +1,135 @@
+// Package statstest provides a statsd.Statter recording metrics in memory,
+// for the tests of the integration packages.
+package statstest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirk91/statsd"
+)
+
+// Recorder records the metrics sent to it by bucket name, the bucket fields
+// joined with dots. It is safe for concurrent use.
+type Recorder struct {
+	statsd.NoopClient
+
+	mu      sync.Mutex
+	calls   map[string]int
+	counts  map[string]float64
+	gauges  map[string]float64
+	timings map[string][]time.Duration
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		calls:   make(map[string]int),
+		counts:  make(map[string]float64),
+		gauges:  make(map[string]float64),
+		timings: make(map[string][]time.Duration),
+	}
+}
+
+// Calls returns the number of metrics of any type sent to name.
+func (r *Recorder) Calls(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[name]
+}
+
+// Count returns the sum of the counts sent to name.
+func (r *Recorder) Count(name string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[name]
+}
+
+// Gauge returns the last gauge sent to name.
+func (r *Recorder) Gauge(name string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gauges[name]
+}
+
+// Timings returns the number of timings sent to name.
+func (r *Recorder) Timings(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.timings[name])
+}
+
+// Counts returns a copy of the count sums by name.
+func (r *Recorder) Counts() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]float64, len(r.counts))
+	for k, v := range r.counts {
+		m[k] = v
+	}
+	return m
+}
+
+// Gauges returns a copy of the last gauges by name.
+func (r *Recorder) Gauges() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[string]float64, len(r.gauges))
+	for k, v := range r.gauges {
+		m[k] = v
+	}
+	return m
+}
+
+func (r *Recorder) count(n float64, bucket []statsd.Field) {
+	name := bucketName(bucket)
+	r.mu.Lock()
+	r.calls[name]++
+	r.counts[name] += n
+	r.mu.Unlock()
+}
+
+func (r *Recorder) gauge(n float64, bucket []statsd.Field) {
+	name := bucketName(bucket)
+	r.mu.Lock()
+	r.calls[name]++
+	r.gauges[name] = n
+	r.mu.Unlock()
+}
+
+func (r *Recorder) Increment(bucket ...statsd.Field)               { r.count(1, bucket) }
+func (r *Recorder) CountInt32(n int32, bucket ...statsd.Field)     { r.count(float64(n), bucket) }
+func (r *Recorder) CountUint32(n uint32, bucket ...statsd.Field)   { r.count(float64(n), bucket) }
+func (r *Recorder) CountInt64(n int64, bucket ...statsd.Field)     { r.count(float64(n), bucket) }
+func (r *Recorder) CountUint64(n uint64, bucket ...statsd.Field)   { r.count(float64(n), bucket) }
+func (r *Recorder) CountFloat64(n float64, bucket ...statsd.Field) { r.count(n, bucket) }
+func (r *Recorder) GaugeInt32(n int32, bucket ...statsd.Field)     { r.gauge(float64(n), bucket) }
+func (r *Recorder) GaugeUint32(n uint32, bucket ...statsd.Field)   { r.gauge(float64(n), bucket) }
+func (r *Recorder) GaugeInt64(n int64, bucket ...statsd.Field)     { r.gauge(float64(n), bucket) }
+func (r *Recorder) GaugeUint64(n uint64, bucket ...statsd.Field)   { r.gauge(float64(n), bucket) }
+func (r *Recorder) GaugeFloat64(n float64, bucket ...statsd.Field) { r.gauge(n, bucket) }
+func (r *Recorder) TimingSince(start time.Time, bucket ...statsd.Field) {
+	r.Timing(time.Since(start), bucket...)
+}
+
+func (r *Recorder) Timing(d time.Duration, bucket ...statsd.Field) {
+	name := bucketName(bucket)
+	r.mu.Lock()
+	r.calls[name]++
+	r.timings[name] = append(r.timings[name], d)
+	r.mu.Unlock()
+}
+
+// bucketName joins the bucket fields with dots.
+func bucketName(bucket []statsd.Field) string {
+	parts := make([]string, len(bucket))
+	for i, f := range bucket {
+		if f.Type == statsd.FieldTypeString {
+			parts[i] = f.Str
+		} else {
+			parts[i] = strconv.FormatInt(f.Int, 10)
+		}
+	}
+	return strings.Join(parts, ".")
+}
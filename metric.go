@@ -3,6 +3,9 @@ package statsd
 import (
 	"fmt"
 	"math"
+	"reflect"
+	"time"
+	"unsafe"
 )
 
 type MetricType uint8
@@ -27,19 +30,37 @@ const (
 	FieldTypeUint8
 	FieldTypeFloat32
 	FieldTypeFloat64
+	FieldTypeTag
+	FieldTypeTimestamp
+	FieldTypePriority
+	FieldTypeBool
+	FieldTypeDuration
+	FieldTypeStringer
 )
 
 type Field struct {
-	Type FieldType
-	Int  int64
-	Str  string
+	Type      FieldType
+	Int       int64
+	Str       string
+	Key       string
+	Interface interface{}
 }
 
 func (f Field) appendTo(b *buf) {
 	switch f.Type {
 	case FieldTypeString:
 		b.AppendString(f.Str)
-	case FieldTypeFloat64:
+	case FieldTypeTag:
+		b.bs = appendSanitized(b.bs, f.Key, dogStatsDReserved)
+		if f.Str != "" {
+			b.AppendString(":")
+			b.bs = appendSanitized(b.bs, f.Str, dogStatsDReserved)
+		}
+	case FieldTypeTimestamp:
+		b.AppendInt64(f.Int)
+	case FieldTypeBool:
+		b.AppendBool(f.Int != 0)
+	case FieldTypeDuration, FieldTypeFloat64:
 		b.AppendFloat64(math.Float64frombits(uint64(f.Int)))
 	case FieldTypeFloat32:
 		b.AppendFloat32(math.Float32frombits(uint32(f.Int)))
@@ -59,11 +80,31 @@ func (f Field) appendTo(b *buf) {
 		b.AppendUint32(uint32(f.Int))
 	case FieldTypeUint64:
 		b.AppendUint64(uint64(f.Int))
+	case FieldTypeStringer:
+		appendStringer(b, f.Interface)
 	default:
 		panic(fmt.Sprintf("unknown field type: %v", f.Type))
 	}
 }
 
+// appendStringer appends the String() of s, or "nil" for a nil s or a nil
+// pointer whose String method panics.
+func appendStringer(b *buf, s interface{}) {
+	if s == nil {
+		b.AppendString("nil")
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if v := reflect.ValueOf(s); v.Kind() != reflect.Pointer || !v.IsNil() {
+				panic(r)
+			}
+			b.AppendString("nil")
+		}
+	}()
+	b.AppendString(s.(fmt.Stringer).String())
+}
+
 func String(val string) Field {
 	return Field{Type: FieldTypeString, Str: val}
 }
@@ -105,14 +146,99 @@ func Float64(val float64) Field {
 	return Field{Type: FieldTypeFloat64, Int: int64(math.Float64bits(val))}
 }
 
-func encode(typ MetricType, val Field, prefix string, hostname string, bucket []Field) *buf {
-	n := len(bucket)
-	if n == 0 {
+func Bool(val bool) Field {
+	var i int64
+	if val {
+		i = 1
+	}
+	return Field{Type: FieldTypeBool, Int: i}
+}
+
+// Duration returns a field holding d in multiples of unit, e.g. "1.5" for
+// 1500ms in seconds.
+func Duration(d time.Duration, unit time.Duration) Field {
+	return Field{Type: FieldTypeDuration, Int: int64(math.Float64bits(float64(d) / float64(unit)))}
+}
+
+// Bytes returns a field holding val without copying it, so val must not be
+// modified while the field is in use.
+func Bytes(val []byte) Field {
+	return Field{Type: FieldTypeString, Str: unsafe.String(unsafe.SliceData(val), len(val))}
+}
+
+// Stringer returns a field calling val.String() only when the metric is
+// encoded. Pass a pointer to avoid allocating val in an interface. A nil val,
+// or a nil pointer whose String method panics, is written as "nil".
+func Stringer(val fmt.Stringer) Field {
+	return Field{Type: FieldTypeStringer, Interface: val}
+}
+
+// Tag returns a tag field. Tags are not part of the bucket name, they are
+// sent in the way of the wire format, see WireFormat. An empty value yields
+// a tag consisting of key only.
+func Tag(key, value string) Field {
+	return Field{Type: FieldTypeTag, Key: key, Str: value}
+}
+
+// Timestamp returns a field setting the time of a metric, sent as the
+// DogStatsD "|T<unix timestamp>" extension on gauges and counts. Like tags,
+// it is not part of the bucket name. Other wire formats and metric types
+// can't carry a timestamp, see StrictTimestamps.
+func Timestamp(t time.Time) Field {
+	return Field{Type: FieldTypeTimestamp, Int: t.Unix()}
+}
+
+// isName reports whether f is part of the bucket name.
+func (f Field) isName() bool {
+	return f.Type != FieldTypeTag && f.Type != FieldTypeTimestamp && f.Type != FieldTypePriority
+}
+
+// encode fills b.m with the metric, the name and value being encoded into
+// b.scratch, a float value with prec decimals unless negative. The caller
+// formats b.m into b.bs, see Client.format.
+func encode(typ MetricType, val Field, prec int, prefix string, hostname string, tags []Field, bucket []Field) *buf {
+	if !hasName(bucket) {
 		return nil
 	}
 
 	b := getBuf()
 
+	// swap scratch in, so the name and value are appended to it
+	b.bs, b.scratch = b.scratch, b.bs
+	appendName(b, prefix, hostname, bucket)
+	nameLen := len(b.bs)
+	b.prec = prec
+	val.appendTo(b)
+	b.prec = -1
+	b.bs, b.scratch = b.scratch, b.bs
+
+	m := &b.m
+	m.Type = typ
+	m.Name = b.scratch[:nameLen]
+	m.Value = b.scratch[nameLen:]
+	m.Tags = append(m.Tags[:0], tags...)
+	for i := range bucket {
+		switch bucket[i].Type {
+		case FieldTypeTag:
+			m.Tags = append(m.Tags, bucket[i])
+		case FieldTypeTimestamp:
+			m.Timestamp = bucket[i].Int
+		}
+	}
+
+	return b
+}
+
+func hasName(bucket []Field) bool {
+	for i := range bucket {
+		if bucket[i].isName() {
+			return true
+		}
+	}
+	return false
+}
+
+func appendName(b *buf, prefix string, hostname string, bucket []Field) {
 	if prefix != "" {
 		b.AppendString(prefix)
 		b.AppendString(".")
@@ -122,39 +248,25 @@ func encode(typ MetricType, val Field, prefix string, hostname string, bucket []
 		b.AppendString(".")
 	}
 
-	last := n - 1
+	first := true
 	for i := range bucket {
-		bucket[i].appendTo(b)
-		if i < last {
+		if !bucket[i].isName() {
+			continue
+		}
+		if !first {
 			b.AppendString(".")
 		}
+		bucket[i].appendTo(b)
+		first = false
 	}
-
-	b.AppendString(":")
-	val.appendTo(b)
-
-	b.AppendString("|")
-	switch typ {
-	case MetricTypeGauge:
-		b.AppendString("g\n")
-	case MetricTypeCount:
-		b.AppendString("c\n")
-	case MetricTypeTiming:
-		b.AppendString("ms\n")
-	default:
-		panic(fmt.Sprintf("unknown field type: %v", typ))
-	}
-
-	return b
 }
 
-func encodeTpl(typ MetricType, val Field, prefix string, hostname string, template string, fmtArgs []interface{}) *buf {
+func formatTpl(template string, fmtArgs []interface{}) string {
 	msg := template
 	if msg == "" && len(fmtArgs) > 0 {
 		msg = fmt.Sprint(fmtArgs...)
 	} else if msg != "" && len(fmtArgs) > 0 {
 		msg = fmt.Sprintf(template, fmtArgs...)
 	}
-
-	return encode(typ, val, prefix, hostname, []Field{String(msg)})
+	return msg
 }
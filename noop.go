@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"context"
+	"time"
+)
+
+// Statter is implemented by *Client and NoopClient. Libraries can accept a
+// Statter and emit metrics unconditionally.
+type Statter interface {
+	Increment(bucket ...Field)
+	CountInt32(n int32, bucket ...Field)
+	CountUint32(n uint32, bucket ...Field)
+	CountInt64(n int64, bucket ...Field)
+	CountUint64(n uint64, bucket ...Field)
+	CountFloat64(n float64, bucket ...Field)
+	GaugeInt32(n int32, bucket ...Field)
+	GaugeUint32(n uint32, bucket ...Field)
+	GaugeInt64(n int64, bucket ...Field)
+	GaugeUint64(n uint64, bucket ...Field)
+	GaugeFloat64(n float64, bucket ...Field)
+	TimingSince(start time.Time, bucket ...Field)
+	Timing(duration time.Duration, bucket ...Field)
+
+	Incrementf(template string, args ...interface{})
+	CountInt32f(n int32, template string, args ...interface{})
+	CountUint32f(n uint32, template string, args ...interface{})
+	CountInt64f(n int64, template string, args ...interface{})
+	CountUint64f(n uint64, template string, args ...interface{})
+	CountFloat64f(n float64, template string, args ...interface{})
+	GaugeInt32f(n int32, template string, args ...interface{})
+	GaugeUint32f(n uint32, template string, args ...interface{})
+	GaugeInt64f(n int64, template string, args ...interface{})
+	GaugeUint64f(n uint64, template string, args ...interface{})
+	GaugeFloat64f(n float64, template string, args ...interface{})
+	Timingf(duration time.Duration, template string, args ...interface{})
+	TimingSincef(start time.Time, template string, args ...interface{})
+
+	IncrementWithHost(bucket ...Field)
+	CountInt32WithHost(n int32, bucket ...Field)
+	CountUint32WithHost(n uint32, bucket ...Field)
+	CountInt64WithHost(n int64, bucket ...Field)
+	CountUint64WithHost(n uint64, bucket ...Field)
+	CountFloat64WithHost(n float64, bucket ...Field)
+	GaugeInt32WithHost(n int32, bucket ...Field)
+	GaugeUint32WithHost(n uint32, bucket ...Field)
+	GaugeInt64WithHost(n int64, bucket ...Field)
+	GaugeUint64WithHost(n uint64, bucket ...Field)
+	GaugeFloat64WithHost(n float64, bucket ...Field)
+	TimingSinceWithHost(start time.Time, bucket ...Field)
+	TimingWithHost(duration time.Duration, bucket ...Field)
+
+	IncrementfWithHost(template string, args ...interface{})
+	CountInt32fWithHost(n int32, template string, args ...interface{})
+	CountUint32fWithHost(n uint32, template string, args ...interface{})
+	CountInt64fWithHost(n int64, template string, args ...interface{})
+	CountUint64fWithHost(n uint64, template string, args ...interface{})
+	CountFloat64fWithHost(n float64, template string, args ...interface{})
+	GaugeInt32fWithHost(n int32, template string, args ...interface{})
+	GaugeUint32fWithHost(n uint32, template string, args ...interface{})
+	GaugeInt64fWithHost(n int64, template string, args ...interface{})
+	GaugeUint64fWithHost(n uint64, template string, args ...interface{})
+	GaugeFloat64fWithHost(n float64, template string, args ...interface{})
+	TimingfWithHost(duration time.Duration, template string, args ...interface{})
+	TimingSincefWithHost(start time.Time, template string, args ...interface{})
+
+	IncrementCtx(ctx context.Context, bucket ...Field)
+	CountInt32Ctx(ctx context.Context, n int32, bucket ...Field)
+	CountUint32Ctx(ctx context.Context, n uint32, bucket ...Field)
+	CountInt64Ctx(ctx context.Context, n int64, bucket ...Field)
+	CountUint64Ctx(ctx context.Context, n uint64, bucket ...Field)
+	CountFloat64Ctx(ctx context.Context, n float64, bucket ...Field)
+	GaugeInt32Ctx(ctx context.Context, n int32, bucket ...Field)
+	GaugeUint32Ctx(ctx context.Context, n uint32, bucket ...Field)
+	GaugeInt64Ctx(ctx context.Context, n int64, bucket ...Field)
+	GaugeUint64Ctx(ctx context.Context, n uint64, bucket ...Field)
+	GaugeFloat64Ctx(ctx context.Context, n float64, bucket ...Field)
+	TimingSinceCtx(ctx context.Context, start time.Time, bucket ...Field)
+	TimingCtx(ctx context.Context, duration time.Duration, bucket ...Field)
+}
+
+var (
+	_ Statter = (*Client)(nil)
+	_ Statter = NoopClient{}
+)
+
+// NoopClient is a Statter that discards all metrics.
+type NoopClient struct{}
+
+func (NoopClient) Increment(bucket ...Field)                                                    {}
+func (NoopClient) CountInt32(n int32, bucket ...Field)                                          {}
+func (NoopClient) CountUint32(n uint32, bucket ...Field)                                        {}
+func (NoopClient) CountInt64(n int64, bucket ...Field)                                          {}
+func (NoopClient) CountUint64(n uint64, bucket ...Field)                                        {}
+func (NoopClient) CountFloat64(n float64, bucket ...Field)                                      {}
+func (NoopClient) GaugeInt32(n int32, bucket ...Field)                                          {}
+func (NoopClient) GaugeUint32(n uint32, bucket ...Field)                                        {}
+func (NoopClient) GaugeInt64(n int64, bucket ...Field)                                          {}
+func (NoopClient) GaugeUint64(n uint64, bucket ...Field)                                        {}
+func (NoopClient) GaugeFloat64(n float64, bucket ...Field)                                      {}
+func (NoopClient) TimingSince(start time.Time, bucket ...Field)                                 {}
+func (NoopClient) Timing(duration time.Duration, bucket ...Field)                               {}
+func (NoopClient) Incrementf(template string, args ...interface{})                              {}
+func (NoopClient) CountInt32f(n int32, template string, args ...interface{})                    {}
+func (NoopClient) CountUint32f(n uint32, template string, args ...interface{})                  {}
+func (NoopClient) CountInt64f(n int64, template string, args ...interface{})                    {}
+func (NoopClient) CountUint64f(n uint64, template string, args ...interface{})                  {}
+func (NoopClient) CountFloat64f(n float64, template string, args ...interface{})                {}
+func (NoopClient) GaugeInt32f(n int32, template string, args ...interface{})                    {}
+func (NoopClient) GaugeUint32f(n uint32, template string, args ...interface{})                  {}
+func (NoopClient) GaugeInt64f(n int64, template string, args ...interface{})                    {}
+func (NoopClient) GaugeUint64f(n uint64, template string, args ...interface{})                  {}
+func (NoopClient) GaugeFloat64f(n float64, template string, args ...interface{})                {}
+func (NoopClient) Timingf(duration time.Duration, template string, args ...interface{})         {}
+func (NoopClient) TimingSincef(start time.Time, template string, args ...interface{})           {}
+func (NoopClient) IncrementWithHost(bucket ...Field)                                            {}
+func (NoopClient) CountInt32WithHost(n int32, bucket ...Field)                                  {}
+func (NoopClient) CountUint32WithHost(n uint32, bucket ...Field)                                {}
+func (NoopClient) CountInt64WithHost(n int64, bucket ...Field)                                  {}
+func (NoopClient) CountUint64WithHost(n uint64, bucket ...Field)                                {}
+func (NoopClient) CountFloat64WithHost(n float64, bucket ...Field)                              {}
+func (NoopClient) GaugeInt32WithHost(n int32, bucket ...Field)                                  {}
+func (NoopClient) GaugeUint32WithHost(n uint32, bucket ...Field)                                {}
+func (NoopClient) GaugeInt64WithHost(n int64, bucket ...Field)                                  {}
+func (NoopClient) GaugeUint64WithHost(n uint64, bucket ...Field)                                {}
+func (NoopClient) GaugeFloat64WithHost(n float64, bucket ...Field)                              {}
+func (NoopClient) TimingSinceWithHost(start time.Time, bucket ...Field)                         {}
+func (NoopClient) TimingWithHost(duration time.Duration, bucket ...Field)                       {}
+func (NoopClient) IncrementfWithHost(template string, args ...interface{})                      {}
+func (NoopClient) CountInt32fWithHost(n int32, template string, args ...interface{})            {}
+func (NoopClient) CountUint32fWithHost(n uint32, template string, args ...interface{})          {}
+func (NoopClient) CountInt64fWithHost(n int64, template string, args ...interface{})            {}
+func (NoopClient) CountUint64fWithHost(n uint64, template string, args ...interface{})          {}
+func (NoopClient) CountFloat64fWithHost(n float64, template string, args ...interface{})        {}
+func (NoopClient) GaugeInt32fWithHost(n int32, template string, args ...interface{})            {}
+func (NoopClient) GaugeUint32fWithHost(n uint32, template string, args ...interface{})          {}
+func (NoopClient) GaugeInt64fWithHost(n int64, template string, args ...interface{})            {}
+func (NoopClient) GaugeUint64fWithHost(n uint64, template string, args ...interface{})          {}
+func (NoopClient) GaugeFloat64fWithHost(n float64, template string, args ...interface{})        {}
+func (NoopClient) TimingfWithHost(duration time.Duration, template string, args ...interface{}) {}
+func (NoopClient) TimingSincefWithHost(start time.Time, template string, args ...interface{})   {}
+
+func (NoopClient) IncrementCtx(ctx context.Context, bucket ...Field)                      {}
+func (NoopClient) CountInt32Ctx(ctx context.Context, n int32, bucket ...Field)            {}
+func (NoopClient) CountUint32Ctx(ctx context.Context, n uint32, bucket ...Field)          {}
+func (NoopClient) CountInt64Ctx(ctx context.Context, n int64, bucket ...Field)            {}
+func (NoopClient) CountUint64Ctx(ctx context.Context, n uint64, bucket ...Field)          {}
+func (NoopClient) CountFloat64Ctx(ctx context.Context, n float64, bucket ...Field)        {}
+func (NoopClient) GaugeInt32Ctx(ctx context.Context, n int32, bucket ...Field)            {}
+func (NoopClient) GaugeUint32Ctx(ctx context.Context, n uint32, bucket ...Field)          {}
+func (NoopClient) GaugeInt64Ctx(ctx context.Context, n int64, bucket ...Field)            {}
+func (NoopClient) GaugeUint64Ctx(ctx context.Context, n uint64, bucket ...Field)          {}
+func (NoopClient) GaugeFloat64Ctx(ctx context.Context, n float64, bucket ...Field)        {}
+func (NoopClient) TimingSinceCtx(ctx context.Context, start time.Time, bucket ...Field)   {}
+func (NoopClient) TimingCtx(ctx context.Context, duration time.Duration, bucket ...Field) {}
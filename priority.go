@@ -0,0 +1,66 @@
+package statsd
+
+// MetricPriority decides which metrics are shed first when the send queue
+// is full, see QueueSize.
+type MetricPriority uint8
+
+const (
+	PriorityLow MetricPriority = iota
+	PriorityNormal
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+// Priority returns a field setting the priority of a metric, overriding the
+// one of the client. Like tags, it is not part of the bucket name.
+//
+// Queued metrics are sent highest priority first, so metrics of different
+// priorities may arrive out of order. A gauge sent at two priorities can end
+// up with the older value.
+func Priority(p MetricPriority) Field {
+	return Field{Type: FieldTypePriority, Int: int64(p)}
+}
+
+// QueueSize caps the bytes queued for sending, 64 packets by default. When
+// a metric doesn't fit, queued metrics of lower priority are dropped, lowest
+// first, and if that doesn't make room the metric itself is dropped.
+func QueueSize(n int) Option {
+	return func(o *options) {
+		o.queueSize = n
+	}
+}
+
+// WithPriority returns a client sending its metrics with priority p, and
+// PriorityNormal by default. It shares the connection with c, so closing
+// either closes both. Its metrics are sent ahead of or after the queued
+// metrics of c, so send a gauge through one client only, or it may end up
+// with a stale value.
+func (c *Client) WithPriority(p MetricPriority) *Client {
+	sub := *c
+	sub.opts.priority = p
+	return &sub
+}
+
+// Dropped returns the number of metrics of priority p dropped because the
+// queue was full.
+func (c *Client) Dropped(p MetricPriority) uint64 {
+	if c.cc == nil || p >= numPriorities {
+		return 0
+	}
+	return c.cc.dropped[p].Load()
+}
+
+// priorityOf returns the priority set by the last Priority field in bucket,
+// or def.
+func priorityOf(bucket []Field, def MetricPriority) MetricPriority {
+	for i := range bucket {
+		if bucket[i].Type == FieldTypePriority {
+			def = MetricPriority(bucket[i].Int)
+		}
+	}
+	if def >= numPriorities {
+		return PriorityHigh
+	}
+	return def
+}
@@ -0,0 +1,80 @@
+package statsd
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// RateLimit bounds the metrics sent per name to about perSecond. A name
+// exceeding it is sampled, at a rate halved whenever the budget is used up
+// again within the second, and for the next second at the rate which would
+// have kept it within budget. Sampled counts and timings carry the rate,
+// e.g. "foo:1|c|@0.25", so the server can scale them back. Gauges are
+// sampled as is. Timings summarized by TimingSummary are not limited.
+func RateLimit(perSecond int) Option {
+	return func(o *options) {
+		o.rateLimit = perSecond
+	}
+}
+
+type rateLimiter struct {
+	limit uint64
+
+	mu     sync.Mutex
+	second int64
+	names  map[string]*nameRate
+}
+
+type nameRate struct {
+	seen, kept uint64 // in the current second
+	rate       float64
+}
+
+func newRateLimiter(limit int) *rateLimiter {
+	return &rateLimiter{limit: uint64(limit), names: make(map[string]*nameRate)}
+}
+
+// sample reports whether a metric named name is kept and its sampling rate.
+func (l *rateLimiter) sample(name []byte) (float64, bool) {
+	now := time.Now().Unix()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now != l.second {
+		l.rollover(now)
+	}
+	r, ok := l.names[string(name)]
+	if !ok {
+		r = &nameRate{rate: 1}
+		l.names[string(name)] = r
+	}
+	r.seen++
+	if r.rate < 1 && rand.Float64() >= r.rate {
+		return 0, false
+	}
+	rate := r.rate
+	r.kept++
+	if r.kept%l.limit == 0 {
+		r.rate /= 2
+	}
+	return rate, true
+}
+
+// rollover starts a new second, deriving the rates from the last one.
+func (l *rateLimiter) rollover(now int64) {
+	elapsed := now - l.second
+	l.second = now
+	for name, r := range l.names {
+		if r.seen == 0 || elapsed > 1 {
+			delete(l.names, name)
+			continue
+		}
+		r.rate = 1
+		for float64(r.seen)*r.rate > float64(l.limit) {
+			r.rate /= 2
+		}
+		r.seen, r.kept = 0, 0
+	}
+}
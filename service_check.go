@@ -0,0 +1,93 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+type ServiceCheckStatus uint8
+
+const (
+	ServiceCheckOK ServiceCheckStatus = iota
+	ServiceCheckWarning
+	ServiceCheckCritical
+	ServiceCheckUnknown
+)
+
+// ServiceCheck is a DogStatsD service check. Name is required.
+type ServiceCheck struct {
+	Name      string
+	Status    ServiceCheckStatus
+	Timestamp time.Time
+	Hostname  string
+	Message   string
+	Tags      []Field // see Tag
+}
+
+var (
+	errServiceCheckName   = errors.New("statsd: service check name is required")
+	errServiceCheckStatus = errors.New("statsd: unknown service check status")
+)
+
+// ServiceCheck sends sc in the DogStatsD format
+//
+//	_sc|<prefix>.<name>|<status>|d:<timestamp>|h:<hostname>|#<tags>|m:<message>
+//
+// along with the client tags. Newlines in the message are escaped as "\n".
+// Invalid service checks are reported to the ErrorHandler.
+func (c *Client) ServiceCheck(sc *ServiceCheck) {
+	c.serviceCheck(sc, sc.Hostname)
+}
+
+// ServiceCheckWithHost is like ServiceCheck, but defaults the hostname of sc
+// to the client hostname.
+func (c *Client) ServiceCheckWithHost(sc *ServiceCheck) {
+	hostname := sc.Hostname
+	if hostname == "" {
+		hostname = c.opts.hostname
+	}
+	c.serviceCheck(sc, hostname)
+}
+
+func (c *Client) serviceCheck(sc *ServiceCheck, hostname string) {
+	if c.cc == nil {
+		return
+	}
+	if sc.Name == "" {
+		c.handleError(errServiceCheckName)
+		return
+	}
+	if sc.Status > ServiceCheckUnknown {
+		c.handleError(errServiceCheckStatus)
+		return
+	}
+	b := encodeServiceCheck(sc, c.opts.prefix, hostname, c.opts.tags)
+	c.cc.write(b.Bytes(), c.opts.priority)
+	freeBuf(b)
+}
+
+func encodeServiceCheck(sc *ServiceCheck, prefix string, hostname string, tags []Field) *buf {
+	b := getBuf()
+	b.AppendString("_sc|")
+	if prefix != "" {
+		b.AppendString(prefix)
+		b.AppendString(".")
+	}
+	b.AppendString(sc.Name)
+	b.AppendString("|")
+	b.AppendUint8(uint8(sc.Status))
+
+	if !sc.Timestamp.IsZero() {
+		b.AppendString("|d:")
+		b.AppendInt64(sc.Timestamp.Unix())
+	}
+	appendOptional(b, "|h:", hostname)
+	appendTags(b, tags, sc.Tags)
+	if sc.Message != "" {
+		b.AppendString("|m:")
+		appendEscaped(b, strings.Replace(sc.Message, "m:", `m\:`, -1))
+	}
+	b.AppendString("\n")
+	return b
+}
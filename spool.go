@@ -0,0 +1,231 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// SpoolConfig configures the on-disk spool, see Spool.
+type SpoolConfig struct {
+	Dir string
+
+	// MaxBytes caps the size of the spool, the oldest segments are dropped
+	// to stay below it. Defaults to 64 MiB.
+	MaxBytes int64
+	// SegmentBytes is the size at which a new segment file is started.
+	// Defaults to 1 MiB.
+	SegmentBytes int64
+}
+
+// SpoolStats counts the bytes that went through the spool.
+type SpoolStats struct {
+	Spooled  uint64
+	Replayed uint64
+	Dropped  uint64
+}
+
+// Spool makes a client on a stream network write metrics it fails to send
+// to segment files in cfg.Dir instead of dropping them. Once the connection
+// works again, the segments are replayed oldest first, before any new
+// metrics. Segments left behind by a previous process are replayed as well,
+// with a torn last line dropped. Replay is at least once: a segment failing
+// halfway is sent again in full. If the endpoint is down when the client is
+// created, New still succeeds and metrics are spooled until it is up.
+func Spool(cfg SpoolConfig) Option {
+	return func(o *options) {
+		o.spool = &cfg
+	}
+}
+
+// SpoolStats returns the spool counters of the client.
+func (c *Client) SpoolStats() SpoolStats {
+	if c.cc == nil || c.cc.spool == nil {
+		return SpoolStats{}
+	}
+	s := c.cc.spool
+	return SpoolStats{
+		Spooled:  s.spooled.Load(),
+		Replayed: s.replayed.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+}
+
+const spoolExt = ".spool"
+
+// spool is used by clientConn under its lock. All methods handle a nil
+// spool, which is not spooling.
+type spool struct {
+	cfg SpoolConfig
+
+	segments []string // oldest first, the last one may be cur
+	sizes    map[string]int64
+	size     int64
+	seq      uint64
+	cur      *os.File
+
+	spooled, replayed, dropped atomic.Uint64
+}
+
+func openSpool(cfg SpoolConfig) (*spool, error) {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.SegmentBytes <= 0 {
+		cfg.SegmentBytes = 1 << 20
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{cfg: cfg, sizes: make(map[string]int64)}
+	for _, e := range entries {
+		name := e.Name()
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, name)
+		s.sizes[name] = info.Size()
+		s.size += info.Size()
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+	sort.Strings(s.segments)
+	return s, nil
+}
+
+func (s *spool) pending() bool {
+	return s != nil && len(s.segments) > 0
+}
+
+func (s *spool) write(b []byte) error {
+	n := int64(len(b))
+	if n > s.cfg.MaxBytes {
+		s.dropped.Add(uint64(n))
+		return nil
+	}
+	for s.size+n > s.cfg.MaxBytes && len(s.segments) > 0 {
+		if err := s.remove(s.segments[0], true); err != nil {
+			return err
+		}
+	}
+
+	if s.cur == nil || s.sizes[s.last()]+n > s.cfg.SegmentBytes {
+		if err := s.rotate(); err != nil {
+			s.dropped.Add(uint64(n))
+			return err
+		}
+	}
+	if _, err := s.cur.Write(b); err != nil {
+		s.dropped.Add(uint64(n))
+		return err
+	}
+	s.sizes[s.last()] += n
+	s.size += n
+	s.spooled.Add(uint64(n))
+	return nil
+}
+
+// replay writes the segments oldest first with write, removing each one
+// that was written.
+func (s *spool) replay(write func([]byte) error) error {
+	if !s.pending() {
+		return nil
+	}
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	for len(s.segments) > 0 {
+		name := s.segments[0]
+		data, err := os.ReadFile(filepath.Join(s.cfg.Dir, name))
+		if err != nil {
+			return err
+		}
+		// drop a torn line from a crash
+		end := bytes.LastIndexByte(data, '\n') + 1
+		s.dropped.Add(uint64(len(data) - end))
+		if end > 0 {
+			if err := write(data[:end]); err != nil {
+				return err
+			}
+			s.replayed.Add(uint64(end))
+		}
+		if err := s.remove(name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spool) close() error {
+	if s == nil {
+		return nil
+	}
+	return s.closeCurrent()
+}
+
+func (s *spool) last() string {
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *spool) rotate() error {
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolExt)
+	f, err := os.OpenFile(filepath.Join(s.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.cur = f
+	s.segments = append(s.segments, name)
+	s.sizes[name] = 0
+	return nil
+}
+
+func (s *spool) closeCurrent() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Sync()
+	if cerr := s.cur.Close(); err == nil {
+		err = cerr
+	}
+	s.cur = nil
+	return err
+}
+
+// remove deletes the oldest segment name, counting it as dropped if drop.
+func (s *spool) remove(name string, drop bool) error {
+	if s.cur != nil && name == s.last() {
+		if err := s.closeCurrent(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(s.cfg.Dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if drop {
+		s.dropped.Add(uint64(s.sizes[name]))
+	}
+	s.size -= s.sizes[name]
+	delete(s.sizes, name)
+	s.segments = s.segments[1:]
+	return nil
+}
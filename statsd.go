@@ -1,9 +1,14 @@
 package statsd
 
 import (
+	"crypto/tls"
+	"errors"
+	"math"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/kirk91/statsd/internal/poll"
 )
 
 type options struct {
@@ -14,6 +19,22 @@ type options struct {
 
 	prefix   string
 	hostname string
+	tags     []Field
+
+	noopFallback     bool
+	aggregator       *Aggregator
+	summary          *Summary
+	strictTimestamps bool
+	formatter        Formatter
+	tlsConfig        *tls.Config
+	spool            *SpoolConfig
+	queueSize        int
+	priority         MetricPriority
+	rateLimit        int
+	cardinality      *CardinalityConfig
+	gaugeTimeout     time.Duration
+	timingUnit       time.Duration
+	timingPrecision  int
 }
 
 type Option func(*options)
@@ -54,14 +75,83 @@ func Hostname(hostname string) Option {
 	}
 }
 
+// Tags sets tags appended to every metric sent by the client, see Tag.
+func Tags(tags ...Field) Option {
+	return func(o *options) {
+		o.tags = tags
+	}
+}
+
+// TimingUnit sets the unit timings are sent in, time.Millisecond by
+// default. Use time.Nanosecond, time.Microsecond, time.Millisecond or
+// time.Second, matching what the server expects. Only the value is scaled:
+// the type suffix stays "ms", e.g. "foo:0.25|ms" for 250ms in seconds.
+func TimingUnit(unit time.Duration) Option {
+	return func(o *options) {
+		o.timingUnit = unit
+	}
+}
+
+// TimingPrecision rounds timings to the given number of decimals, trailing
+// zeros removed. By default, or if decimals is negative, they are sent with
+// full precision.
+func TimingPrecision(decimals int) Option {
+	return func(o *options) {
+		o.timingPrecision = decimals
+	}
+}
+
+// TLSConfig makes the client connect with TLS using config, e.g. to set
+// client certificates or root CAs. It is also enabled by suffixing the
+// network with "+tls", e.g. "tcp+tls", using the default config. TLS
+// requires a stream network: "tcp", "tcp4", "tcp6" or "unix".
+func TLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// StrictTimestamps makes the client drop metrics carrying a Timestamp field
+// the wire format can't send, and report them to the ErrorHandler. By
+// default the timestamp is left out.
+func StrictTimestamps() Option {
+	return func(o *options) {
+		o.strictTimestamps = true
+	}
+}
+
+// NoopFallback makes New return a client that discards all metrics instead
+// of an error when addr is empty or dialing fails. The dial error, if any,
+// is reported to the ErrorHandler.
+func NoopFallback() Option {
+	return func(o *options) {
+		o.noopFallback = true
+	}
+}
+
+// AggregateTo additionally records every metric sent by the client in a.
+func AggregateTo(a *Aggregator) Option {
+	return func(o *options) {
+		o.aggregator = a
+	}
+}
+
+var errTimestamp = errors.New("statsd: timestamp not supported by the wire format for the metric type")
+
 type Client struct {
 	opts options
 
-	cc *clientConn
+	cc          *clientConn
+	summaries   *timingSummaries
+	limiter     *rateLimiter
+	guard       *cardinalityGuard
+	gauges      *gaugeRegistry
+	summaryLoop *poll.Loop // only without a connection
 }
 
 func New(network, addr string, opt ...Option) (*Client, error) {
-	c := &Client{}
+	c := &Client{gauges: newGaugeRegistry()}
+	c.opts.timingPrecision = -1
 	for _, o := range opt {
 		o(&c.opts)
 	}
@@ -79,17 +169,80 @@ func New(network, addr string, opt ...Option) (*Client, error) {
 	if c.opts.maxPacketSize <= 0 {
 		c.opts.maxPacketSize = 1400
 	}
+	if c.opts.timingUnit <= 0 {
+		c.opts.timingUnit = time.Millisecond
+	}
+	if c.opts.gaugeTimeout <= 0 {
+		c.opts.gaugeTimeout = c.opts.flushPeriod
+	}
+	if c.opts.queueSize <= 0 {
+		c.opts.queueSize = 64 * c.opts.maxPacketSize
+	}
+	c.opts.priority = PriorityNormal
+	if c.opts.formatter == nil {
+		c.opts.formatter = DogStatsD
+	}
+
+	if c.opts.summary != nil {
+		c.summaries = newTimingSummaries(*c.opts.summary)
+	}
+	if c.opts.cardinality != nil {
+		c.guard = newCardinalityGuard(*c.opts.cardinality)
+	}
+	if c.opts.rateLimit > 0 {
+		c.limiter = newRateLimiter(c.opts.rateLimit)
+	}
+
+	if addr == "" && c.opts.noopFallback {
+		c.startLoops()
+		return c, nil
+	}
 
 	cc, err := newClientConn(network, addr, c)
 	if err != nil {
-		return nil, err
+		if !c.opts.noopFallback {
+			return nil, err
+		}
+		c.handleError(err)
+		c.startLoops()
+		return c, nil
 	}
 
 	c.cc = cc
+	c.startLoops()
 
 	return c, nil
 }
 
+// startLoops starts flushing the summaries of a client without a
+// connection, so they still reach the aggregator.
+func (c *Client) startLoops() {
+	if c.cc == nil && c.opts.aggregator != nil {
+		c.summaryLoop = poll.Start(c.opts.flushPeriod, c.flushSummaries)
+	}
+}
+
+// Close flushes any buffered metrics and closes the underlying connection.
+// It waits up to the GaugeTimeout for gauge callbacks in progress.
+func (c *Client) Close() error {
+	c.gauges.stop()
+	if c.cc == nil {
+		if c.summaryLoop != nil {
+			c.summaryLoop.Stop()
+		}
+		c.flushSummaries()
+		return nil
+	}
+	c.flushSummaries()
+	return c.cc.close()
+}
+
+func (c *Client) flushSummaries() {
+	if c.summaries != nil {
+		c.summaries.flush(c)
+	}
+}
+
 func (c *Client) Increment(bucket ...Field) {
 	c.CountInt32(1, bucket...)
 }
@@ -110,6 +263,10 @@ func (c *Client) CountUint64(n uint64, bucket ...Field) {
 	c.send(c.encode(MetricTypeCount, Uint64(n), bucket))
 }
 
+func (c *Client) CountFloat64(n float64, bucket ...Field) {
+	c.send(c.encode(MetricTypeCount, Float64(n), bucket))
+}
+
 func (c *Client) GaugeInt32(n int32, bucket ...Field) {
 	c.send(c.encode(MetricTypeGauge, Int32(n), bucket))
 }
@@ -131,11 +288,11 @@ func (c *Client) GaugeFloat64(n float64, bucket ...Field) {
 }
 
 func (c *Client) TimingSince(start time.Time, bucket ...Field) {
-	c.send(c.encode(MetricTypeTiming, Float64(float64(time.Now().Sub(start).Nanoseconds())/float64(time.Millisecond)), bucket))
+	c.send(c.encode(MetricTypeTiming, c.timing(time.Since(start)), bucket))
 }
 
 func (c *Client) Timing(duration time.Duration, bucket ...Field) {
-	c.send(c.encode(MetricTypeTiming, Float64(float64(duration)/float64(time.Millisecond)), bucket))
+	c.send(c.encode(MetricTypeTiming, c.timing(duration), bucket))
 }
 
 func (c *Client) Incrementf(template string, args ...interface{}) {
@@ -158,6 +315,10 @@ func (c *Client) CountUint64f(n uint64, template string, args ...interface{}) {
 	c.send(c.encodeTpl(MetricTypeCount, Uint64(n), template, args))
 }
 
+func (c *Client) CountFloat64f(n float64, template string, args ...interface{}) {
+	c.send(c.encodeTpl(MetricTypeCount, Float64(n), template, args))
+}
+
 func (c *Client) GaugeInt32f(n int32, template string, args ...interface{}) {
 	c.send(c.encodeTpl(MetricTypeGauge, Int32(n), template, args))
 }
@@ -179,11 +340,11 @@ func (c *Client) GaugeFloat64f(n float64, template string, args ...interface{})
 }
 
 func (c *Client) Timingf(duration time.Duration, template string, args ...interface{}) {
-	c.send(c.encodeTpl(MetricTypeTiming, Float64(float64(duration)/float64(time.Millisecond)), template, args))
+	c.send(c.encodeTpl(MetricTypeTiming, c.timing(duration), template, args))
 }
 
 func (c *Client) TimingSincef(start time.Time, template string, args ...interface{}) {
-	c.send(c.encodeTpl(MetricTypeTiming, Float64(float64(time.Now().Sub(start).Nanoseconds())/float64(time.Millisecond)), template, args))
+	c.send(c.encodeTpl(MetricTypeTiming, c.timing(time.Since(start)), template, args))
 }
 
 func (c *Client) IncrementWithHost(bucket ...Field) {
@@ -206,6 +367,10 @@ func (c *Client) CountUint64WithHost(n uint64, bucket ...Field) {
 	c.send(c.encodeWithHost(MetricTypeCount, Uint64(n), bucket))
 }
 
+func (c *Client) CountFloat64WithHost(n float64, bucket ...Field) {
+	c.send(c.encodeWithHost(MetricTypeCount, Float64(n), bucket))
+}
+
 func (c *Client) GaugeInt32WithHost(n int32, bucket ...Field) {
 	c.send(c.encodeWithHost(MetricTypeGauge, Int32(n), bucket))
 }
@@ -227,12 +392,11 @@ func (c *Client) GaugeFloat64WithHost(n float64, bucket ...Field) {
 }
 
 func (c *Client) TimingSinceWithHost(start time.Time, bucket ...Field) {
-	elapsed := float64(time.Now().Sub(start).Nanoseconds()) / float64(time.Millisecond)
-	c.send(c.encodeWithHost(MetricTypeTiming, Float64(elapsed), bucket))
+	c.send(c.encodeWithHost(MetricTypeTiming, c.timing(time.Since(start)), bucket))
 }
 
 func (c *Client) TimingWithHost(duration time.Duration, bucket ...Field) {
-	c.send(c.encodeWithHost(MetricTypeTiming, Float64(float64(duration)/float64(time.Millisecond)), bucket))
+	c.send(c.encodeWithHost(MetricTypeTiming, c.timing(duration), bucket))
 }
 
 func (c *Client) IncrementfWithHost(template string, args ...interface{}) {
@@ -255,6 +419,10 @@ func (c *Client) CountUint64fWithHost(n uint64, template string, args ...interfa
 	c.send(c.encodeTplWithHost(MetricTypeCount, Uint64(n), template, args))
 }
 
+func (c *Client) CountFloat64fWithHost(n float64, template string, args ...interface{}) {
+	c.send(c.encodeTplWithHost(MetricTypeCount, Float64(n), template, args))
+}
+
 func (c *Client) GaugeInt32fWithHost(n int32, template string, args ...interface{}) {
 	c.send(c.encodeTplWithHost(MetricTypeGauge, Int32(n), template, args))
 }
@@ -276,36 +444,118 @@ func (c *Client) GaugeFloat64fWithHost(n float64, template string, args ...inter
 }
 
 func (c *Client) TimingfWithHost(duration time.Duration, template string, args ...interface{}) {
-	b := c.encodeTplWithHost(MetricTypeTiming, Float64(float64(duration)/float64(time.Millisecond)), template, args)
+	b := c.encodeTplWithHost(MetricTypeTiming, c.timing(duration), template, args)
 	c.send(b)
 }
 
 func (c *Client) TimingSincefWithHost(start time.Time, template string, args ...interface{}) {
-	elapsed := float64(time.Now().Sub(start).Nanoseconds()) / float64(time.Millisecond)
-	b := c.encodeTplWithHost(MetricTypeTiming, Float64(elapsed), template, args)
+	b := c.encodeTplWithHost(MetricTypeTiming, c.timing(time.Since(start)), template, args)
 	c.send(b)
 }
 
+// timing returns d in the timing unit.
+func (c *Client) timing(d time.Duration) Field {
+	return Float64(float64(d) / float64(c.opts.timingUnit))
+}
+
 func (c *Client) encode(typ MetricType, val Field, bucket []Field) *buf {
-	return encode(typ, val, c.opts.prefix, "", bucket)
+	return c.encodeBucket(typ, val, "", bucket)
 }
 
 func (c *Client) encodeWithHost(typ MetricType, val Field, bucket []Field) *buf {
-	return encode(typ, val, c.opts.prefix, c.opts.hostname, bucket)
+	return c.encodeBucket(typ, val, c.opts.hostname, bucket)
 }
 
 func (c *Client) encodeTpl(typ MetricType, val Field, template string, fmtArgs []interface{}) *buf {
-	return encodeTpl(typ, val, c.opts.prefix, "", template, fmtArgs)
+	if c.discard() {
+		return nil
+	}
+	return c.encodeBucket(typ, val, "", []Field{String(formatTpl(template, fmtArgs))})
 }
 
 func (c *Client) encodeTplWithHost(typ MetricType, val Field, template string, fmtArgs []interface{}) *buf {
-	return encodeTpl(typ, val, c.opts.prefix, c.opts.hostname, template, fmtArgs)
+	if c.discard() {
+		return nil
+	}
+	return c.encodeBucket(typ, val, c.opts.hostname, []Field{String(formatTpl(template, fmtArgs))})
+}
+
+func (c *Client) encodeBucket(typ MetricType, val Field, hostname string, bucket []Field) *buf {
+	if c.discard() {
+		return nil
+	}
+	prec := -1
+	if typ == MetricTypeTiming {
+		prec = c.opts.timingPrecision
+	}
+	b := encode(typ, val, prec, c.opts.prefix, hostname, c.opts.tags, bucket)
+	if b == nil {
+		return nil
+	}
+	if b.m.Timestamp != 0 && !c.opts.formatter.Timestamps(typ) {
+		if c.opts.strictTimestamps {
+			freeBuf(b)
+			c.handleError(errTimestamp)
+			return nil
+		}
+		b.m.Timestamp = 0
+	}
+	if c.guard != nil {
+		skip := bucketOffset(c.opts.prefix, hostname)
+		if !c.guard.allow(&b.m, skip) {
+			if c.guard.cfg.Fallback == "" {
+				freeBuf(b)
+				c.handleError(errCardinality)
+				return nil
+			}
+			c.guard.overflow(b, skip, len(c.opts.tags))
+		}
+	}
+	if typ == MetricTypeTiming && c.summaries != nil {
+		c.summaries.add(&b.m, math.Float64frombits(uint64(val.Int)))
+		freeBuf(b)
+		return nil
+	}
+	if c.limiter != nil {
+		rate, ok := c.limiter.sample(b.m.Name)
+		if !ok {
+			freeBuf(b)
+			return nil
+		}
+		if typ != MetricTypeGauge {
+			b.m.Rate = rate
+		}
+	}
+	b.prio = priorityOf(bucket, c.opts.priority)
+	c.format(b)
+	return b
+}
+
+// format appends b.m in the wire format to b.
+func (c *Client) format(b *buf) {
+	b.bs = c.opts.formatter.AppendMetric(b.bs, &b.m)
+}
+
+func (c *Client) handleError(err error) {
+	if c.opts.errHandler != nil {
+		c.opts.errHandler(err)
+	}
+}
+
+// discard reports whether metrics go nowhere, so encoding can be skipped.
+func (c *Client) discard() bool {
+	return c.cc == nil && c.opts.aggregator == nil
 }
 
 func (c *Client) send(b *buf) {
 	if b == nil {
 		return
 	}
-	c.cc.write(b.Bytes())
+	if c.opts.aggregator != nil {
+		c.opts.aggregator.add(&b.m)
+	}
+	if c.cc != nil {
+		c.cc.write(b.Bytes(), b.prio)
+	}
 	freeBuf(b)
 }
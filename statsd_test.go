@@ -1,11 +1,21 @@
 package statsd_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,8 +41,10 @@ func TestNewClient(t *testing.T) {
 
 type mockServer struct {
 	l      net.PacketConn
-	closed bool
-	buf    bytes.Buffer
+	closed atomic.Bool
+
+	mu  sync.Mutex
+	buf bytes.Buffer
 }
 
 func newMockServer(t *testing.T) *mockServer {
@@ -46,7 +58,7 @@ func newMockServer(t *testing.T) *mockServer {
 	go func() {
 		b := make([]byte, 1024)
 		for {
-			if s.closed {
+			if s.closed.Load() {
 				return
 			}
 			n, _, err := l.ReadFrom(b)
@@ -54,7 +66,9 @@ func newMockServer(t *testing.T) *mockServer {
 				continue
 			}
 			assert.NoError(t, err)
+			s.mu.Lock()
 			s.buf.Write(b[:n])
+			s.mu.Unlock()
 		}
 	}()
 
@@ -66,15 +80,19 @@ func (s *mockServer) Addr() string {
 }
 
 func (s *mockServer) Close() {
-	s.closed = true
+	s.closed.Store(true)
 	s.l.Close()
 }
 
 func (s *mockServer) Reset() {
+	s.mu.Lock()
 	s.buf.Reset()
+	s.mu.Unlock()
 }
 
 func (s *mockServer) Content() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return string(s.buf.Bytes())
 }
 
@@ -88,6 +106,7 @@ func TestIncrement(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 	hostname := Hostname()
 
 	c.Increment(statsd.Int8(1), statsd.Int16(200), statsd.Int32(1000), statsd.Int64(10000))
@@ -115,6 +134,7 @@ func TestIncrementf(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 	c.Incrementf("foo.%s", "bar")
 	time.Sleep(time.Millisecond * 100)
 	assert.Equal(t, "foo.bar:1|c\n", s.Content())
@@ -130,6 +150,7 @@ func TestCount(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 
 	c.CountInt32(1, statsd.String("foo"))
 	c.CountUint32(3, statsd.String("foo"))
@@ -152,6 +173,7 @@ func TestCountf(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 	c.CountInt32f(1, "", "foo")
 	c.CountUint32f(3, "%s", "foo")
 	c.CountInt64f(10, "bar")
@@ -173,6 +195,7 @@ func TestGauge(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 
 	c.GaugeInt32(1)
 	c.GaugeUint32(1, statsd.String("foo"), statsd.String("bar"))
@@ -197,6 +220,7 @@ func TestGaugef(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 
 	c.GaugeInt32f(1, "%s.%s", "foo", "bar")
 	c.GaugeUint32f(1, "%s.%s", "foo", "bar")
@@ -221,6 +245,7 @@ func TestTiming(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 
 	c.Timing(10*time.Millisecond, statsd.String("foo"))
 	time.Sleep(time.Millisecond * 100)
@@ -248,6 +273,7 @@ func TestTimingf(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Nanosecond*500))
+	defer c.Close()
 	c.Timingf(10*time.Millisecond, "foo")
 	time.Sleep(time.Millisecond * 100)
 	assert.Equal(t, "foo:10|ms\n", s.Content())
@@ -274,6 +300,7 @@ func TestPrefix(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.Prefix("juju"))
+	defer c.Close()
 	c.Increment(statsd.String("foo"))
 	c.Increment(statsd.String("bar"))
 	c.CountInt32(3, statsd.String("zoo"))
@@ -288,6 +315,7 @@ func TestHostname(t *testing.T) {
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.Prefix("juju"), statsd.Hostname("fake-host"))
+	defer c.Close()
 	c.Increment(statsd.String("foo"))
 	c.IncrementWithHost(statsd.String("bar"))
 	c.CountInt32(3, statsd.String("zoo"))
@@ -297,11 +325,311 @@ func TestHostname(t *testing.T) {
 	assert.Equal(t, "juju.foo:1|c\njuju.fake-host.bar:1|c\njuju.zoo:3|c\njuju.fake-host.kong:10|c\njuju.mong:100|g\n", s.Content())
 }
 
+func TestTags(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.Tags(statsd.Tag("env", "prod")))
+	defer c.Close()
+	c.Increment(statsd.String("foo"), statsd.Tag("code", "200"), statsd.String("bar"), statsd.Tag("canary", ""))
+	c.GaugeInt32f(1, "foo.%s", "bar")
+	c.Increment(statsd.Tag("code", "200"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "foo.bar:1|c|#env:prod,code:200,canary\nfoo.bar:1|g|#env:prod\n", s.Content())
+}
+
+func TestTimingSummary(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.TimingSummary(statsd.Summary{
+		Count:     "count",
+		Max:       "upper",
+		Quantiles: []statsd.Quantile{{Q: 0.9, Suffix: "p90"}},
+	}))
+	defer c.Close()
+	for i := 1; i <= 100; i++ {
+		c.Timing(time.Duration(i)*time.Millisecond, statsd.String("foo"), statsd.Tag("env", "prod"))
+	}
+	c.GaugeInt32(1, statsd.String("bar"))
+	time.Sleep(200 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(s.Content()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "bar:1|g", lines[0])
+	assert.Equal(t, "foo.count:100|g|#env:prod", lines[1])
+	assert.Equal(t, "foo.upper:100|g|#env:prod", lines[2])
+	var p90 float64
+	fmt.Sscanf(lines[3], "foo.p90:%g|g|#env:prod", &p90)
+	assert.InEpsilon(t, 90, p90, 0.01)
+}
+
+func TestTimingSummaryNoConn(t *testing.T) {
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a), statsd.TimingPrecision(1),
+		statsd.FlushPeriod(20*time.Millisecond), statsd.TimingSummary(statsd.Summary{Sum: "sum", Max: "max"}))
+	c.Timing(1234*time.Microsecond, statsd.String("foo"))
+	c.Timing(1111*time.Microsecond, statsd.String("foo"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []statsd.Aggregate{
+		{Name: "foo.max", Type: statsd.MetricTypeGauge, Value: 1.2},
+		{Name: "foo.sum", Type: statsd.MetricTypeGauge, Value: 2.3},
+	}, a.Snapshot())
+
+	c, _ = statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a),
+		statsd.FlushPeriod(time.Hour), statsd.TimingSummary(statsd.Summary{Max: "max"}))
+	c.Timing(5*time.Millisecond, statsd.String("bar"))
+	c.Close()
+	assert.Contains(t, a.Snapshot(), statsd.Aggregate{Name: "bar.max", Type: statsd.MetricTypeGauge, Value: 5})
+}
+
+func TestEvent(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	var gotErr bool
+	c, _ := statsd.New("udp", s.Addr(), statsd.Tags(statsd.Tag("env", "prod")), statsd.ErrorHandler(func(error) {
+		gotErr = true
+	}))
+	defer c.Close()
+	c.Event(&statsd.Event{Title: "deploy", Text: "v1.2\nrollout"})
+	c.Event(&statsd.Event{
+		Title:          "oom",
+		Timestamp:      time.Unix(1500000000, 0),
+		Hostname:       "web1",
+		AggregationKey: "mem",
+		Priority:       statsd.EventPriorityLow,
+		SourceTypeName: "kernel",
+		AlertType:      statsd.EventAlertTypeError,
+		Tags:           []statsd.Field{statsd.Tag("pod", "a|b,c")},
+	})
+	c.Event(&statsd.Event{Text: "no title"})
+	time.Sleep(200 * time.Millisecond)
+
+	assert.True(t, gotErr)
+	assert.Equal(t, "_e{6,13}:deploy|v1.2\\nrollout|#env:prod\n"+
+		"_e{3,0}:oom||d:1500000000|h:web1|k:mem|p:low|s:kernel|t:error|#env:prod,pod:a_b_c\n", s.Content())
+}
+
+func TestServiceCheck(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	var errs int
+	c, _ := statsd.New("udp", s.Addr(), statsd.Prefix("juju"), statsd.Hostname("fake-host"), statsd.ErrorHandler(func(error) {
+		errs++
+	}))
+	defer c.Close()
+	c.ServiceCheck(&statsd.ServiceCheck{Name: "db", Status: statsd.ServiceCheckOK})
+	c.ServiceCheckWithHost(&statsd.ServiceCheck{
+		Name:      "cache",
+		Status:    statsd.ServiceCheckCritical,
+		Timestamp: time.Unix(1500000000, 0),
+		Message:   "down\nm:retrying",
+		Tags:      []statsd.Field{statsd.Tag("zone", "a")},
+	})
+	c.ServiceCheck(&statsd.ServiceCheck{Status: statsd.ServiceCheckOK})
+	c.ServiceCheck(&statsd.ServiceCheck{Name: "db", Status: 4})
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Equal(t, 2, errs)
+	assert.Equal(t, "_sc|juju.db|0\n"+
+		"_sc|juju.cache|2|d:1500000000|h:fake-host|#zone:a|m:down\\nm\\:retrying\n", s.Content())
+}
+
+func TestTimestamp(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	ts := statsd.Timestamp(time.Unix(1500000000, 0))
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond))
+	defer c.Close()
+	c.GaugeInt32(1, statsd.String("foo"), ts)
+	c.CountInt32(2, ts, statsd.String("bar"), statsd.Tag("env", "prod"))
+	c.Timing(time.Millisecond, statsd.String("zoo"), ts)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "foo:1|g|T1500000000\nbar:2|c|#env:prod|T1500000000\nzoo:1|ms\n", s.Content())
+
+	s.Reset()
+	var gotErr bool
+	c, _ = statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.StrictTimestamps(), statsd.ErrorHandler(func(error) {
+		gotErr = true
+	}))
+	defer c.Close()
+	c.Timing(time.Millisecond, statsd.String("zoo"), ts)
+	c.GaugeInt32(1, statsd.String("foo"), ts)
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, gotErr)
+	assert.Equal(t, "foo:1|g|T1500000000\n", s.Content())
+}
+
+func TestWireFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format statsd.Formatter
+		want   string
+		// parse splits a line into name, tags and value with type
+		parse func(line string) (string, []string, string)
+	}{
+		{"etsy", statsd.Etsy, "foo:1|c\n", func(line string) (string, []string, string) {
+			i := strings.IndexByte(line, ':')
+			return line[:i], nil, line[i+1:]
+		}},
+		{"dogstatsd", statsd.DogStatsD, "foo:1|c|#env:prod,canary,path:a_b_c=d:e;[f]_g\n", func(line string) (string, []string, string) {
+			i := strings.IndexByte(line, ':')
+			j := strings.Index(line, "|#")
+			return line[:i], strings.Split(line[j+2:], ","), line[i+1 : j]
+		}},
+		{"influxdb", statsd.InfluxDB, "foo,env=prod,path=a_b|c_d_e__f__g:1|c\n", func(line string) (string, []string, string) {
+			i := strings.LastIndexByte(line, ':')
+			parts := strings.Split(line[:i], ",")
+			return parts[0], parts[1:], line[i+1:]
+		}},
+		{"graphite", statsd.GraphiteTags, "foo;env=prod;path=a_b|c_d_e__f__g:1|c\n", func(line string) (string, []string, string) {
+			i := strings.LastIndexByte(line, ':')
+			parts := strings.Split(line[:i], ";")
+			return parts[0], parts[1:], line[i+1:]
+		}},
+		{"signalfx", statsd.SignalFx, "foo[env=prod,path=a_b|c_d_e__f__g]:1|c\n", func(line string) (string, []string, string) {
+			i := strings.IndexByte(line, '[')
+			j := strings.IndexByte(line, ']')
+			return line[:i], strings.Split(line[i+1:j], ","), line[j+2:]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMockServer(t)
+			defer s.Close()
+
+			c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.WireFormat(tt.format))
+			defer c.Close()
+			c.Increment(statsd.String("foo"), statsd.Tag("env", "prod"), statsd.Tag("canary", ""),
+				statsd.Tag("path", "a,b|c=d:e;[f]\ng"))
+			time.Sleep(200 * time.Millisecond)
+			assert.Equal(t, tt.want, s.Content())
+
+			name, tags, value := tt.parse(strings.TrimSuffix(s.Content(), "\n"))
+			assert.Equal(t, "foo", name)
+			assert.Equal(t, "1|c", value)
+			for _, tag := range tags {
+				assert.Contains(t, []string{"env:prod", "env=prod", "canary", "path:a_b_c=d:e;[f]_g", "path=a_b|c_d_e__f__g"}, tag)
+			}
+		})
+	}
+}
+
+func TestWireFormatTimestamp(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	var gotErr bool
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.WireFormat(statsd.InfluxDB),
+		statsd.StrictTimestamps(), statsd.ErrorHandler(func(error) {
+			gotErr = true
+		}))
+	defer c.Close()
+	c.GaugeInt32(1, statsd.String("foo"), statsd.Timestamp(time.Unix(1500000000, 0)))
+	c.GaugeInt32(2, statsd.String("bar"))
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, gotErr)
+	assert.Equal(t, "bar:2|g\n", s.Content())
+}
+
+func TestGeneric(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond))
+	defer c.Close()
+	statsd.Count(c, 0.5, statsd.String("a"))
+	statsd.Count(c, int8(-1), statsd.String("b"))
+	statsd.Gauge(c, len("abc"), statsd.String("c"))
+	statsd.Gauge(c, uint16(65535), statsd.String("d"))
+	statsd.Gauge(c, float32(1.5), statsd.String("e"))
+	statsd.Countf(c, uint(2), "f.%d", 1)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "a:0.5|c\nb:-1|c\nc:3|g\nd:65535|g\ne:1.5|g\nf.1:2|c\n", s.Content())
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		statsd.Gauge(c, 1.5, statsd.String("foo"))
+	})
+	assert.Zero(t, allocs)
+	allocs = testing.AllocsPerRun(1000, func() {
+		c.CountInt64(1, statsd.String("foo"))
+	})
+	assert.Zero(t, allocs)
+}
+
+type region struct{ name string }
+
+func (r *region) String() string { return r.name }
+
+func TestFieldKinds(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond))
+	defer c.Close()
+	name := []byte("cache")
+	eu := &region{"eu"}
+	c.Increment(statsd.Bytes(name), statsd.Bool(true), statsd.Stringer(eu), statsd.Duration(1500*time.Millisecond, time.Second))
+	c.Increment(statsd.Bool(false), statsd.Duration(90*time.Second, time.Minute))
+	c.Increment(statsd.String("region"), statsd.Stringer(nil), statsd.Stringer((*region)(nil)))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "cache.true.eu.1.5:1|c\nfalse.1.5:1|c\nregion.nil.nil:1|c\n", s.Content())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Increment(statsd.Bytes(name), statsd.Bool(true), statsd.Stringer(eu), statsd.Duration(time.Second, time.Millisecond))
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestCountFloat64(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond))
+	defer c.Close()
+	c.CountFloat64(0.25, statsd.String("cost"))
+	c.CountFloat64f(1.5, "cost.%s", "eu")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "cost:0.25|c\ncost.eu:1.5|c\n", s.Content())
+}
+
+func TestTimingUnitAndPrecision(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.TimingPrecision(3))
+	defer c.Close()
+	c.Timing(123456789*time.Nanosecond, statsd.String("a"))
+	c.Timing(2*time.Millisecond, statsd.String("b"))
+	c.GaugeFloat64(0.123456789, statsd.String("c"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "a:123.457|ms\nb:2|ms\nc:0.123456789|g\n", s.Content())
+
+	s.Reset()
+	c, _ = statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.TimingUnit(time.Second), statsd.TimingPrecision(2))
+	defer c.Close()
+	c.Timing(1234*time.Millisecond, statsd.String("a"))
+	c.Timing(time.Microsecond, statsd.String("b"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "a:1.23|ms\nb:0|ms\n", s.Content())
+
+	s.Reset()
+	c, _ = statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.TimingUnit(time.Microsecond))
+	defer c.Close()
+	c.Timing(1500*time.Nanosecond, statsd.String("a"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "a:1.5|ms\n", s.Content())
+}
+
 func TestMaxPacketSize(t *testing.T) {
 	s := newMockServer(t)
 	defer s.Close()
 
 	c, _ := statsd.New("udp", s.Addr(), statsd.MaxPacketSize(20))
+	defer c.Close()
 	c.Increment(statsd.String("foo.bar.zoo"))
 	c.Increment(statsd.String("foo.bar.zoo"))
 	time.Sleep(time.Millisecond * 80)
@@ -318,14 +646,504 @@ func TestErrorHandler(t *testing.T) {
 	c, _ := statsd.New("tcp", l.Addr().String(), statsd.ErrorHandler(func(error) {
 		gotErr = true
 	}), statsd.FlushPeriod(50*time.Nanosecond))
+	defer c.Close()
 	c.Increment(statsd.String("foo.bar.zoo"))
 	l.Close() // close listener
 	time.Sleep(time.Millisecond * 200)
 	assert.True(t, gotErr)
 }
 
+func TestRegisterGauge(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	var mu sync.Mutex
+	var errs []string
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.GaugeTimeout(20*time.Millisecond),
+		statsd.ErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err.Error())
+			mu.Unlock()
+		}))
+	defer c.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	id := c.RegisterGauge([]statsd.Field{statsd.String("queue")}, func() float64 { return 3 })
+	c.RegisterGauge([]statsd.Field{statsd.String("panic")}, func() float64 { panic("boom") })
+	c.RegisterGauge([]statsd.Field{statsd.String("slow")}, func() float64 {
+		<-release
+		return 1
+	})
+	time.Sleep(120 * time.Millisecond)
+	assert.Contains(t, s.Content(), "queue:3|g\n")
+	assert.NotContains(t, s.Content(), "panic")
+	assert.NotContains(t, s.Content(), "slow")
+	mu.Lock()
+	assert.Contains(t, errs, "statsd: gauge callback panicked: boom")
+	assert.Contains(t, errs, "statsd: gauge callback timed out")
+	mu.Unlock()
+
+	c.Unregister(id)
+	time.Sleep(60 * time.Millisecond)
+	s.Reset()
+	time.Sleep(120 * time.Millisecond)
+	assert.NotContains(t, s.Content(), "queue")
+}
+
+func TestRegisterGaugeSlow(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.QueueSize(20000))
+	defer c.Close()
+	c.RegisterGauge([]statsd.Field{statsd.String("slow")}, func() float64 {
+		time.Sleep(45 * time.Millisecond)
+		return 1
+	})
+	for start := time.Now(); time.Since(start) < 300*time.Millisecond; {
+		for i := 0; i < 100; i++ {
+			c.Increment(statsd.String("foo"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Zero(t, c.Dropped(statsd.PriorityNormal))
+}
+
+func TestRegisterGaugeNoConn(t *testing.T) {
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a), statsd.FlushPeriod(20*time.Millisecond))
+	defer c.Close()
+	bucket := []statsd.Field{statsd.String("queue")}
+	c.RegisterGauge(bucket, func() float64 { return 3 })
+	bucket[0] = statsd.String("changed")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []statsd.Aggregate{{Name: "queue", Type: statsd.MetricTypeGauge, Value: 3}}, a.Snapshot())
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", "", statsd.NoopFallback(), statsd.Prefix("app"), statsd.AggregateTo(a),
+		statsd.CardinalityLimit(statsd.CardinalityConfig{Limit: 10, Fallback: "overflow"}))
+	for i := 0; i < 100; i++ {
+		c.Incrementf("user.%d.login", i)
+	}
+	c.Increment(statsd.String("api"), statsd.String("ok"))
+
+	names := map[string]float64{}
+	for _, agg := range a.Snapshot() {
+		names[agg.Name] = agg.Value
+	}
+	assert.Equal(t, float64(1), names["app.api.ok"])
+	assert.Equal(t, float64(90), names["app.overflow"])
+	assert.Len(t, names, 12)
+
+	var errs int
+	c, _ = statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(statsd.NewAggregator()),
+		statsd.ErrorHandler(func(error) { errs++ }),
+		statsd.CardinalityLimit(statsd.CardinalityConfig{Limit: 10}))
+	for i := 0; i < 100; i++ {
+		c.Increment(statsd.String("user"), statsd.Tag("id", strconv.Itoa(i)))
+	}
+	assert.Equal(t, 90, errs)
+
+	a = statsd.NewAggregator()
+	c, _ = statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a),
+		statsd.CardinalityLimit(statsd.CardinalityConfig{Limit: 10, Fallback: "overflow", MaxPrefixes: 5}))
+	for i := 0; i < 100; i++ {
+		c.Incrementf("%d.login", i)
+	}
+	names = map[string]float64{}
+	for _, agg := range a.Snapshot() {
+		names[agg.Name] = agg.Value
+	}
+	assert.Len(t, names, 6)
+	assert.Equal(t, float64(1), names["4.login"])
+	assert.Equal(t, float64(95), names["overflow"])
+
+	a = statsd.NewAggregator()
+	c, _ = statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a),
+		statsd.CardinalityLimit(statsd.CardinalityConfig{Limit: 1000, Fallback: "overflow"}))
+	for i := 0; i < 3000; i++ {
+		c.Incrementf("user.%d", i)
+	}
+	for i := 0; i < 1000; i++ {
+		c.Incrementf("user.%d", i)
+	}
+	names = map[string]float64{}
+	for _, agg := range a.Snapshot() {
+		names[agg.Name] = agg.Value
+	}
+	assert.Equal(t, float64(2000), names["overflow"])
+	assert.Len(t, names, 1001)
+}
+
+func TestRateLimit(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.MaxPacketSize(1000),
+		statsd.RateLimit(100), statsd.AggregateTo(a))
+	defer c.Close()
+	for i := 0; i < 10000; i++ {
+		c.Increment(statsd.String("foo"))
+	}
+	c.Increment(statsd.String("bar"))
+	time.Sleep(200 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSuffix(s.Content(), "\n"), "\n")
+	assert.Less(t, len(lines), 1000)
+	assert.Contains(t, lines, "bar:1|c")
+	assert.Contains(t, s.Content(), "foo:1|c|@0.5\n")
+
+	aggs := a.Snapshot()
+	if assert.Len(t, aggs, 2) {
+		assert.Equal(t, "foo", aggs[1].Name)
+		assert.InDelta(t, 10000, aggs[1].Value, 3000)
+	}
+}
+
+func TestRateLimitUnbiased(t *testing.T) {
+	const trials, sends = 200, 1000
+	var total float64
+	for i := 0; i < trials; i++ {
+		a := statsd.NewAggregator()
+		c, _ := statsd.New("udp", "", statsd.NoopFallback(), statsd.RateLimit(4), statsd.AggregateTo(a))
+		for j := 0; j < sends; j++ {
+			c.Increment(statsd.String("foo"))
+		}
+		total += a.Snapshot()[0].Value
+	}
+	assert.InEpsilon(t, sends, total/trials, 0.1)
+}
+
+func TestContextTags(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(50*time.Millisecond), statsd.Tags(statsd.Tag("env", "prod")))
+	defer c.Close()
+	ctx := statsd.ContextWithTags(context.Background(), statsd.Tag("tenant", "acme"))
+	ctx = statsd.ContextWithPrefix(ctx, statsd.String("api"))
+	ctx = statsd.ContextWithTags(ctx, statsd.Tag("region", "eu"))
+	c.IncrementCtx(ctx, statsd.String("requests"), statsd.Tag("code", "200"))
+	c.TimingCtx(context.Background(), time.Millisecond, statsd.String("latency"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "api.requests:1|c|#env:prod,tenant:acme,region:eu,code:200\nlatency:1|ms|#env:prod\n", s.Content())
+	assert.Equal(t, []statsd.Field{statsd.Tag("tenant", "acme"), statsd.Tag("region", "eu")}, statsd.TagsFromContext(ctx))
+}
+
+func TestPriority(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	c, _ := statsd.New("udp", s.Addr(), statsd.FlushPeriod(time.Hour), statsd.QueueSize(20))
+	low := c.WithPriority(statsd.PriorityLow)
+	low.Increment(statsd.String("low"))
+	c.Increment(statsd.String("mid"))
+	c.Increment(statsd.String("top"), statsd.Priority(statsd.PriorityHigh)) // sheds low
+	low.Increment(statsd.String("low"))                                     // dropped
+	c.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "top:1|c\nmid:1|c\n", s.Content())
+	assert.Equal(t, uint64(2), c.Dropped(statsd.PriorityLow))
+	assert.Zero(t, c.Dropped(statsd.PriorityNormal))
+	assert.Zero(t, c.Dropped(statsd.PriorityHigh))
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	cert := srv.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	srv.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("new tls listener failed: %v", err)
+	}
+	defer l.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		got <- line
+	}()
+
+	c, err := statsd.New("tcp+tls", l.Addr().String(), statsd.FlushPeriod(50*time.Millisecond), statsd.TLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+	}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	c.Increment(statsd.String("foo"))
+	select {
+	case line := <-got:
+		assert.Equal(t, "foo:1|c\n", line)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no metric received")
+	}
+
+	_, err = statsd.New("udp", l.Addr().String(), statsd.TLSConfig(&tls.Config{}))
+	assert.Error(t, err)
+}
+
+func TestReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	defer l.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close() // break the first connection
+		conn, err = l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		got <- line
+	}()
+
+	c, _ := statsd.New("tcp", l.Addr().String(), statsd.FlushPeriod(10*time.Millisecond))
+	defer c.Close()
+	deadline := time.After(2 * time.Second)
+	for {
+		c.Increment(statsd.String("foo"))
+		select {
+		case line := <-got:
+			assert.Equal(t, "foo:1|c\n", line)
+			return
+		case <-deadline:
+			t.Fatal("client did not reconnect")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestSpool(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	addr := l.Addr().String()
+	conn := acceptOne(l)
+
+	c, _ := statsd.New("tcp", addr, statsd.FlushPeriod(10*time.Millisecond), statsd.ErrorHandler(func(error) {}),
+		statsd.Spool(statsd.SpoolConfig{Dir: t.TempDir()}))
+	defer c.Close()
+	(<-conn).Close()
+	l.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.SpoolStats().Spooled == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing spooled")
+		}
+		c.Increment(statsd.String("foo"))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	defer l.Close()
+	conn = acceptOne(l)
+	select {
+	case replayed := <-conn:
+		defer replayed.Close()
+		line, _ := bufio.NewReader(replayed).ReadString('\n')
+		assert.Equal(t, "foo:1|c\n", line)
+	case <-time.After(2 * time.Second):
+		t.Fatal("spool not replayed")
+	}
+	c.Close()
+	assert.NotZero(t, c.SpoolStats().Replayed)
+}
+
+func TestSpoolEndpointDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c, err := statsd.New("tcp", addr, statsd.FlushPeriod(10*time.Millisecond), statsd.ErrorHandler(func(error) {}),
+		statsd.Spool(statsd.SpoolConfig{Dir: t.TempDir()}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	c.Increment(statsd.String("foo"))
+	deadline := time.Now().Add(2 * time.Second)
+	for c.SpoolStats().Spooled == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nothing spooled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	defer l.Close()
+	conn := acceptOne(l)
+	select {
+	case replayed := <-conn:
+		defer replayed.Close()
+		line, _ := bufio.NewReader(replayed).ReadString('\n')
+		assert.Equal(t, "foo:1|c\n", line)
+	case <-time.After(2 * time.Second):
+		t.Fatal("spool not replayed")
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	conn := acceptOne(l)
+
+	var dials atomic.Int32
+	c, _ := statsd.New("tcp", l.Addr().String(), statsd.FlushPeriod(10*time.Millisecond), statsd.MaxPacketSize(20),
+		statsd.Spool(statsd.SpoolConfig{Dir: t.TempDir()}), statsd.ErrorHandler(func(err error) {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Op == "dial" {
+				dials.Add(1)
+			}
+		}))
+	defer c.Close()
+	(<-conn).Close()
+	l.Close()
+
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 10; j++ {
+			c.Increment(statsd.String("foo"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotZero(t, dials.Load())
+	assert.LessOrEqual(t, dials.Load(), int32(4))
+	assert.NotZero(t, c.SpoolStats().Spooled)
+}
+
+func TestSpoolCrashReplay(t *testing.T) {
+	dir := t.TempDir()
+	segment := filepath.Join(dir, "00000000000000000001.spool")
+	if err := os.WriteFile(segment, []byte("foo:1|c\nbar:2|c\nzo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	defer l.Close()
+	conn := acceptOne(l)
+
+	c, _ := statsd.New("tcp", l.Addr().String(), statsd.FlushPeriod(10*time.Millisecond), statsd.Spool(statsd.SpoolConfig{Dir: dir}))
+	r := bufio.NewReader(<-conn)
+	line1, _ := r.ReadString('\n')
+	line2, _ := r.ReadString('\n')
+	c.Close()
+	assert.Equal(t, "foo:1|c\nbar:2|c\n", line1+line2)
+	assert.Equal(t, statsd.SpoolStats{Replayed: 16, Dropped: 2}, c.SpoolStats())
+	_, err = os.Stat(segment)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func acceptOne(l net.Listener) <-chan net.Conn {
+	conn := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			conn <- c
+		}
+	}()
+	return conn
+}
+
+func TestNoopFallback(t *testing.T) {
+	_, err := statsd.New("udp", "")
+	assert.Error(t, err)
+
+	c, err := statsd.New("udp", "", statsd.NoopFallback())
+	assert.NoError(t, err)
+	c.Increment(statsd.String("foo"))
+	c.Incrementf("foo.%s", "bar")
+	assert.NoError(t, c.Close())
+
+	var gotErr bool
+	c, err = statsd.New("foo", "127.0.0.1:1", statsd.NoopFallback(), statsd.ErrorHandler(func(error) {
+		gotErr = true
+	}))
+	assert.NoError(t, err)
+	assert.True(t, gotErr)
+	c.GaugeInt32(1, statsd.String("foo"))
+	assert.NoError(t, c.Close())
+}
+
+func TestNoopClient(t *testing.T) {
+	var s statsd.Statter = statsd.NoopClient{}
+	s.Increment(statsd.String("foo"))
+	s.Timing(time.Second, statsd.String("foo"))
+	s.GaugeFloat64fWithHost(1, "foo.%s", "bar")
+}
+
+func TestAggregateTo(t *testing.T) {
+	s := newMockServer(t)
+	defer s.Close()
+
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", s.Addr(), statsd.AggregateTo(a))
+	defer c.Close()
+	c.Increment(statsd.String("foo"))
+	c.CountInt32(2, statsd.String("foo"))
+	c.GaugeInt32(1, statsd.String("bar"))
+	c.GaugeInt32(5, statsd.String("bar"))
+	c.Timing(10*time.Millisecond, statsd.String("zoo"))
+	c.Timing(5*time.Millisecond, statsd.String("zoo"))
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Equal(t, "foo:1|c\nfoo:2|c\nbar:1|g\nbar:5|g\nzoo:10|ms\nzoo:5|ms\n", s.Content())
+	assert.Equal(t, []statsd.Aggregate{
+		{Name: "bar", Type: statsd.MetricTypeGauge, Value: 5},
+		{Name: "foo", Type: statsd.MetricTypeCount, Value: 3},
+		{Name: "zoo", Type: statsd.MetricTypeTiming, Value: 15, Count: 2},
+	}, a.Snapshot())
+
+	a.Reset()
+	assert.Empty(t, a.Snapshot())
+	c.Increment(statsd.String("foo"))
+	assert.Equal(t, []statsd.Aggregate{
+		{Name: "foo", Type: statsd.MetricTypeCount, Value: 1},
+	}, a.Snapshot())
+}
+
 func BenchmarkIncrement(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", 1
 
 	b.ReportAllocs()
@@ -338,6 +1156,7 @@ func BenchmarkIncrement(b *testing.B) {
 
 func BenchmarkIncrementParallel(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", 1
 
 	b.ReportAllocs()
@@ -352,6 +1171,7 @@ func BenchmarkIncrementParallel(b *testing.B) {
 
 func BenchmarkCount(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int32(1)
 
 	b.ReportAllocs()
@@ -364,6 +1184,7 @@ func BenchmarkCount(b *testing.B) {
 
 func BenchmarkCountParallel(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int32(1)
 
 	b.ReportAllocs()
@@ -378,6 +1199,7 @@ func BenchmarkCountParallel(b *testing.B) {
 
 func BenchmarkGauge(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int64(1)
 
 	b.ReportAllocs()
@@ -390,6 +1212,7 @@ func BenchmarkGauge(b *testing.B) {
 
 func BenchmarkGaugeParallel(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int64(1)
 
 	b.ReportAllocs()
@@ -404,6 +1227,7 @@ func BenchmarkGaugeParallel(b *testing.B) {
 
 func BenchmarkTiming(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int32(1)
 
 	b.ReportAllocs()
@@ -416,6 +1240,7 @@ func BenchmarkTiming(b *testing.B) {
 
 func BenchmarkTimingParallel(b *testing.B) {
 	c, _ := statsd.New("udp", "127.0.0.1:1")
+	defer c.Close()
 	foo, bar, zoo := "foo", "bar", int32(1)
 
 	b.ReportAllocs()
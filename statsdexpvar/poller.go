@@ -0,0 +1,200 @@
+// Package statsdexpvar periodically publishes expvar variables to statsd.
+package statsdexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kirk91/statsd"
+	"github.com/kirk91/statsd/internal/poll"
+)
+
+type options struct {
+	interval time.Duration
+	prefix   string
+	include  []string
+	exclude  []string
+	counters []string
+}
+
+type Option func(*options)
+
+// Interval sets how often variables are polled, 10s by default.
+func Interval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// Prefix sets the first bucket segment of every metric, "expvar" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+// Include only publishes values whose flattened name matches one of the
+// path.Match patterns, e.g. "http.*". Everything is included by default.
+func Include(patterns ...string) Option {
+	return func(o *options) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// Exclude skips values, or whole variables, whose flattened name matches
+// one of the path.Match patterns.
+func Exclude(patterns ...string) Option {
+	return func(o *options) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// Counters reports integer values whose flattened name matches one of the
+// path.Match patterns as counters of their increase since the previous
+// poll instead of as gauges, starting from the second poll a value is seen
+// in. Use it for monotonically increasing values.
+func Counters(patterns ...string) Option {
+	return func(o *options) {
+		o.counters = append(o.counters, patterns...)
+	}
+}
+
+func match(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Poller publishes expvar variables on every interval until stopped.
+//
+// *expvar.Int and *expvar.Float variables are published under their name,
+// *expvar.Map entries under <map name>.<key>, and any other variable, such
+// as expvar.Func, by flattening the numbers found in its JSON value, e.g.
+// "memstats.HeapAlloc". Arrays and non-numeric values are skipped.
+// Characters other than letters, digits, '_', '-' and '.' in names are
+// replaced by '_'.
+type Poller struct {
+	c      statsd.Statter
+	prefix statsd.Field
+	opts   options
+	last   map[string]int64
+
+	loop *poll.Loop
+}
+
+// NewPoller creates a Poller and starts polling in the background.
+func NewPoller(c statsd.Statter, opt ...Option) *Poller {
+	o := options{
+		interval: 10 * time.Second,
+		prefix:   "expvar",
+	}
+	for _, f := range opt {
+		f(&o)
+	}
+
+	p := &Poller{
+		c:      c,
+		prefix: statsd.String(o.prefix),
+		opts:   o,
+		last:   make(map[string]int64),
+	}
+	p.loop = poll.Start(p.opts.interval, p.poll)
+	return p
+}
+
+// Stop stops polling and waits for an in-progress poll to finish.
+func (p *Poller) Stop() {
+	p.loop.Stop()
+}
+
+func (p *Poller) poll() {
+	expvar.Do(func(kv expvar.KeyValue) {
+		name := sanitize(kv.Key)
+		if match(p.opts.exclude, name) {
+			return
+		}
+		p.flattenVar(name, kv.Value)
+	})
+}
+
+func (p *Poller) flattenVar(name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		p.reportInt(name, v.Value())
+	case *expvar.Float:
+		p.reportFloat(name, v.Value())
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			p.flattenVar(name+"."+sanitize(kv.Key), kv.Value)
+		})
+	default:
+		d := json.NewDecoder(strings.NewReader(v.String()))
+		d.UseNumber()
+		var val interface{}
+		if err := d.Decode(&val); err != nil {
+			return
+		}
+		p.flattenJSON(name, val)
+	}
+}
+
+func (p *Poller) flattenJSON(name string, v interface{}) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			p.reportInt(name, i)
+		} else if f, err := v.Float64(); err == nil {
+			p.reportFloat(name, f)
+		}
+	case map[string]interface{}:
+		for k, vv := range v {
+			p.flattenJSON(name+"."+sanitize(k), vv)
+		}
+	}
+}
+
+func (p *Poller) included(name string) bool {
+	if match(p.opts.exclude, name) {
+		return false
+	}
+	return len(p.opts.include) == 0 || match(p.opts.include, name)
+}
+
+func (p *Poller) reportInt(name string, v int64) {
+	if !p.included(name) {
+		return
+	}
+	if !match(p.opts.counters, name) {
+		p.c.GaugeInt64(v, p.prefix, statsd.String(name))
+		return
+	}
+	// the first poll only records the baseline
+	last, ok := p.last[name]
+	p.last[name] = v
+	if ok && v >= last {
+		p.c.CountInt64(v-last, p.prefix, statsd.String(name))
+	}
+}
+
+func (p *Poller) reportFloat(name string, v float64) {
+	if !p.included(name) {
+		return
+	}
+	p.c.GaugeFloat64(v, p.prefix, statsd.String(name))
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,58 @@
+package statsdexpvar_test
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdexpvar"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	requests = expvar.NewInt("poller_test_requests")
+	load     = expvar.NewFloat("poller_test_load")
+	pool     = expvar.NewMap("poller_test_pool")
+)
+
+func init() {
+	expvar.Publish("poller_test_func", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"size":  3,
+			"ratio": 0.5,
+			"name":  "ignored",
+			"list":  []int{1, 2},
+			"inner": map[string]int{"a b": 7},
+		}
+	}))
+}
+
+func TestPoller(t *testing.T) {
+	requests.Set(10)
+	load.Set(1.5)
+	idle := new(expvar.Int)
+	idle.Set(2)
+	pool.Set("idle", idle)
+	pool.Set("secret", idle)
+
+	rec := statstest.NewRecorder()
+	p := statsdexpvar.NewPoller(rec,
+		statsdexpvar.Interval(10*time.Millisecond),
+		statsdexpvar.Include("poller_test_*"),
+		statsdexpvar.Exclude("poller_test_pool.secret"),
+		statsdexpvar.Counters("poller_test_requests"))
+	time.Sleep(35 * time.Millisecond)
+	requests.Add(5)
+	time.Sleep(35 * time.Millisecond)
+	p.Stop()
+
+	assert.Equal(t, map[string]float64{
+		"expvar.poller_test_load":           1.5,
+		"expvar.poller_test_pool.idle":      2,
+		"expvar.poller_test_func.size":      3,
+		"expvar.poller_test_func.ratio":     0.5,
+		"expvar.poller_test_func.inner.a_b": 7,
+	}, rec.Gauges())
+	assert.Equal(t, map[string]float64{"expvar.poller_test_requests": 5}, rec.Counts())
+}
@@ -0,0 +1,194 @@
+// Package statsdgrpc provides gRPC interceptors which report call metrics to
+// statsd.
+//
+// Every call is reported under <prefix>.<side>.<service>.<method>, side
+// being "server" or "client", as:
+//
+//	calls.<code>   count, code is the status code name such as OK or NotFound
+//	latency        timing of the whole call
+//	msgs_sent      count, streams only
+//	msgs_received  count, streams only
+//
+// Dots in the fully qualified service name are replaced by underscores.
+package statsdgrpc
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kirk91/statsd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	prefix string
+}
+
+type Option func(*options)
+
+// Prefix sets the first bucket segment of every metric, "grpc" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+func newOptions(opt []Option) options {
+	o := options{prefix: "grpc"}
+	for _, f := range opt {
+		f(&o)
+	}
+	return o
+}
+
+var (
+	server       = statsd.String("server")
+	client       = statsd.String("client")
+	calls        = statsd.String("calls")
+	latency      = statsd.String("latency")
+	msgsSent     = statsd.String("msgs_sent")
+	msgsReceived = statsd.String("msgs_received")
+)
+
+type reporter struct {
+	c       statsd.Statter
+	prefix  statsd.Field
+	side    statsd.Field
+	service statsd.Field
+	method  statsd.Field
+	start   time.Time
+}
+
+func newReporter(c statsd.Statter, o options, side statsd.Field, fullMethod string) *reporter {
+	service, method := splitMethod(fullMethod)
+	return &reporter{
+		c:       c,
+		prefix:  statsd.String(o.prefix),
+		side:    side,
+		service: statsd.String(service),
+		method:  statsd.String(method),
+		start:   time.Now(),
+	}
+}
+
+// splitMethod turns "/pkg.Service/Method" into "pkg_Service" and "Method".
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	service, method := "unknown", "unknown"
+	if i := strings.IndexByte(fullMethod, '/'); i >= 0 {
+		service, method = fullMethod[:i], fullMethod[i+1:]
+	}
+	return strings.Replace(service, ".", "_", -1), method
+}
+
+func (r *reporter) msg(kind statsd.Field) {
+	r.c.Increment(r.prefix, r.side, r.service, r.method, kind)
+}
+
+func (r *reporter) done(err error) {
+	r.c.TimingSince(r.start, r.prefix, r.side, r.service, r.method, latency)
+	r.c.Increment(r.prefix, r.side, r.service, r.method, calls, statsd.String(status.Code(err).String()))
+}
+
+// UnaryServerInterceptor returns a server interceptor reporting unary calls.
+func UnaryServerInterceptor(c statsd.Statter, opt ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opt)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r := newReporter(c, o, server, info.FullMethod)
+		resp, err := handler(ctx, req)
+		r.done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a server interceptor reporting streams.
+func StreamServerInterceptor(c statsd.Statter, opt ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opt)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r := newReporter(c, o, server, info.FullMethod)
+		err := handler(srv, &serverStream{ServerStream: ss, r: r})
+		r.done(err)
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	r *reporter
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.r.msg(msgsSent)
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.r.msg(msgsReceived)
+	}
+	return err
+}
+
+// UnaryClientInterceptor returns a client interceptor reporting unary calls.
+func UnaryClientInterceptor(c statsd.Statter, opt ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opt)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r := newReporter(c, o, client, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		r.done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a client interceptor reporting streams. A
+// stream is reported as finished once RecvMsg returns an error, io.EOF
+// counting as OK.
+func StreamClientInterceptor(c statsd.Statter, opt ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opt)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		r := newReporter(c, o, client, method)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			r.done(err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, r: r}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	r        *reporter
+	finished bool
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.r.msg(msgsSent)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.r.msg(msgsReceived)
+	case s.finished:
+	case err == io.EOF:
+		s.finished = true
+		s.r.done(nil)
+	default:
+		s.finished = true
+		s.r.done(err)
+	}
+	return err
+}
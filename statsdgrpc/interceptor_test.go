@@ -0,0 +1,68 @@
+package statsdgrpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdgrpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestInterceptors(t *testing.T) {
+	srvRec := statstest.NewRecorder()
+	cliRec := statstest.NewRecorder()
+
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(statsdgrpc.UnaryServerInterceptor(srvRec)),
+		grpc.StreamInterceptor(statsdgrpc.StreamServerInterceptor(srvRec)),
+	)
+	hs := health.NewServer()
+	hs.SetServingStatus("foo", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(statsdgrpc.UnaryClientInterceptor(cliRec, statsdgrpc.Prefix("rpc"))),
+		grpc.WithStreamInterceptor(statsdgrpc.StreamClientInterceptor(cliRec, statsdgrpc.Prefix("rpc"))),
+	)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	hc := healthpb.NewHealthClient(conn)
+
+	_, err = hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "foo"})
+	assert.NoError(t, err)
+	_, err = hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "bar"})
+	assert.Error(t, err)
+
+	assert.Equal(t, 1.0, srvRec.Count("grpc.server.grpc_health_v1_Health.Check.calls.OK"))
+	assert.Equal(t, 1.0, srvRec.Count("grpc.server.grpc_health_v1_Health.Check.calls.NotFound"))
+	assert.Equal(t, 2, srvRec.Timings("grpc.server.grpc_health_v1_Health.Check.latency"))
+	assert.Equal(t, 1.0, cliRec.Count("rpc.client.grpc_health_v1_Health.Check.calls.OK"))
+	assert.Equal(t, 1.0, cliRec.Count("rpc.client.grpc_health_v1_Health.Check.calls.NotFound"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := hc.Watch(ctx, &healthpb.HealthCheckRequest{Service: "foo"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	assert.Error(t, err)
+
+	assert.Equal(t, 1.0, cliRec.Count("rpc.client.grpc_health_v1_Health.Watch.msgs_sent"))
+	assert.Equal(t, 1.0, cliRec.Count("rpc.client.grpc_health_v1_Health.Watch.msgs_received"))
+	assert.Equal(t, 1.0, cliRec.Count("rpc.client.grpc_health_v1_Health.Watch.calls.Canceled"))
+}
@@ -0,0 +1,203 @@
+// Package statsdhttp instruments net/http servers and clients with statsd
+// metrics.
+package statsdhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kirk91/statsd"
+)
+
+type options struct {
+	prefix    string
+	routeName func(*http.Request) string
+	hostName  func(*http.Request) string
+}
+
+type Option func(*options)
+
+// Prefix sets the first bucket segment of every metric, "http" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+// RouteName sets the function used to name the route of a served request.
+// It is called after the wrapped handler returns. Returning raw paths is
+// discouraged as every distinct path becomes a distinct metric.
+func RouteName(f func(*http.Request) string) Option {
+	return func(o *options) {
+		o.routeName = f
+	}
+}
+
+func newOptions(opt []Option) options {
+	o := options{
+		prefix:    "http",
+		routeName: patternRouteName,
+		hostName:  urlHostName,
+	}
+	for _, f := range opt {
+		f(&o)
+	}
+	return o
+}
+
+// patternRouteName names a request after the http.ServeMux pattern that
+// matched it, e.g. "GET /items/{id}" becomes "GET_items_id".
+func patternRouteName(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	return sanitize(r.Pattern)
+}
+
+func sanitize(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	sep := false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-':
+			if sep && sb.Len() > 0 {
+				sb.WriteByte('_')
+			}
+			sep = false
+			sb.WriteByte(ch)
+		default:
+			sep = true
+		}
+	}
+	if sb.Len() == 0 {
+		return "root"
+	}
+	return sb.String()
+}
+
+// Middleware returns a function wrapping handlers with Handler.
+func Middleware(c statsd.Statter, opt ...Option) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return Handler(c, h, opt...)
+	}
+}
+
+// Handler wraps h and records, per route:
+//
+//	<prefix>.<route>.requests        count
+//	<prefix>.<route>.latency         timing
+//	<prefix>.<route>.response_bytes  count
+//	<prefix>.<route>.status.<class>  count, class is one of 1xx..5xx
+func Handler(c statsd.Statter, h http.Handler, opt ...Option) http.Handler {
+	o := newOptions(opt)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+
+		prefix, route := statsd.String(o.prefix), statsd.String(o.routeName(r))
+		c.TimingSince(start, prefix, route, statsd.String("latency"))
+		c.Increment(prefix, route, statsd.String("requests"))
+		c.CountInt64(rw.size, prefix, route, statsd.String("response_bytes"))
+		c.Increment(prefix, route, statsd.String("status"), statsd.String(statusClass(rw.status())))
+	})
+}
+
+func statusClass(code int) string {
+	switch {
+	case code < 200:
+		return "1xx"
+	case code < 300:
+		return "2xx"
+	case code < 400:
+		return "3xx"
+	case code < 500:
+		return "4xx"
+	default:
+		return "5xx"
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (w *responseWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.code == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack counts a hijacked connection as switching protocols unless a
+// status was written before.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// ReadFrom keeps the sendfile path of the underlying writer, e.g. for
+// http.ServeContent.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
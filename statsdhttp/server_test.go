@@ -0,0 +1,105 @@
+package statsdhttp_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	rec := statstest.NewRecorder()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	})
+	h := statsdhttp.Handler(rec, mux)
+
+	for _, path := range []string{"/items/1", "/items/2", "/missing", "/nothing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, rec.Count("http.GET_items_id.requests"))
+	assert.Equal(t, 10.0, rec.Count("http.GET_items_id.response_bytes"))
+	assert.Equal(t, 2.0, rec.Count("http.GET_items_id.status.2xx"))
+	assert.Equal(t, 2, rec.Timings("http.GET_items_id.latency"))
+	assert.Equal(t, 1.0, rec.Count("http.missing.status.4xx"))
+	assert.Equal(t, 1.0, rec.Count("http.unmatched.status.4xx"))
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	rec := statstest.NewRecorder()
+	mw := statsdhttp.Middleware(rec, statsdhttp.Prefix("api"), statsdhttp.RouteName(func(r *http.Request) string {
+		return "custom"
+	}))
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/a/b", nil))
+
+	assert.Equal(t, 1.0, rec.Count("api.custom.requests"))
+	assert.Equal(t, 1.0, rec.Count("api.custom.status.5xx"))
+	assert.Equal(t, 0.0, rec.Count("api.custom.response_bytes"))
+}
+
+func TestHijack(t *testing.T) {
+	rec := statstest.NewRecorder()
+	srv := httptest.NewServer(statsdhttp.Middleware(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		conn.Write([]byte(line))
+	})))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	conn.Write([]byte("ping\n"))
+	line, _ := br.ReadString('\n')
+	assert.Equal(t, "ping\n", line)
+
+	deadline := time.Now().Add(time.Second)
+	for rec.Count("http.unmatched.requests") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1.0, rec.Count("http.unmatched.status.1xx"))
+}
+
+func TestReadFrom(t *testing.T) {
+	rec := statstest.NewRecorder()
+	h := statsdhttp.Handler(rec, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, strings.NewReader("hello"))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, 5.0, rec.Count("http.unmatched.response_bytes"))
+	assert.Equal(t, 1.0, rec.Count("http.unmatched.status.2xx"))
+}
@@ -0,0 +1,139 @@
+package statsdhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/kirk91/statsd"
+)
+
+// HostName sets the function used to name the target host of an outgoing
+// request. By default the URL host without port is used, with every run of
+// characters other than letters, digits and '-' replaced by '_'.
+func HostName(f func(*http.Request) string) Option {
+	return func(o *options) {
+		o.hostName = f
+	}
+}
+
+func urlHostName(r *http.Request) string {
+	return sanitize(r.URL.Hostname())
+}
+
+type transport struct {
+	c    statsd.Statter
+	base http.RoundTripper
+	opts options
+
+	mu       sync.Mutex
+	inflight map[string]int64
+}
+
+// Transport wraps base, http.DefaultTransport if nil, and records, per host:
+//
+//	<prefix>.client.<host>.requests        count
+//	<prefix>.client.<host>.latency         timing, until response headers
+//	<prefix>.client.<host>.status.<class>  count, class is one of 1xx..5xx
+//	<prefix>.client.<host>.errors.<kind>   count, kind is one of dns,
+//	                                       connect, tls, timeout, canceled
+//	                                       or other
+//	<prefix>.client.<host>.inflight        gauge
+func Transport(c statsd.Statter, base http.RoundTripper, opt ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{
+		c:        c,
+		base:     base,
+		opts:     newOptions(opt),
+		inflight: make(map[string]int64),
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	prefix, client := statsd.String(t.opts.prefix), statsd.String("client")
+	host := statsd.String(t.opts.hostName(req))
+
+	tr := &errTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+
+	t.c.GaugeInt64(t.addInflight(host.Str, 1), prefix, client, host, statsd.String("inflight"))
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.c.TimingSince(start, prefix, client, host, statsd.String("latency"))
+	t.c.GaugeInt64(t.addInflight(host.Str, -1), prefix, client, host, statsd.String("inflight"))
+
+	t.c.Increment(prefix, client, host, statsd.String("requests"))
+	if err != nil {
+		t.c.Increment(prefix, client, host, statsd.String("errors"), statsd.String(tr.kind(err)))
+		return resp, err
+	}
+	t.c.Increment(prefix, client, host, statsd.String("status"), statsd.String(statusClass(resp.StatusCode)))
+	return resp, nil
+}
+
+func (t *transport) addInflight(host string, delta int64) int64 {
+	t.mu.Lock()
+	n := t.inflight[host] + delta
+	if n == 0 {
+		delete(t.inflight, host)
+	} else {
+		t.inflight[host] = n
+	}
+	t.mu.Unlock()
+	return n
+}
+
+// errTrace remembers the connection phase which failed first. Hooks may be
+// called from other goroutines than the one running RoundTrip.
+type errTrace struct {
+	mu    sync.Mutex
+	phase string
+}
+
+func (t *errTrace) set(phase string, err error) {
+	if err == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.phase == "" {
+		t.phase = phase
+	}
+	t.mu.Unlock()
+}
+
+func (t *errTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.set("dns", info.Err)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.set("connect", err)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.set("tls", err)
+		},
+	}
+}
+
+func (t *errTrace) kind(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.phase != "" {
+		return t.phase
+	}
+	return "other"
+}
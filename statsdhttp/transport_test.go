@@ -0,0 +1,66 @@
+package statsdhttp_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer s.Close()
+
+	rec := statstest.NewRecorder()
+	client := &http.Client{Transport: statsdhttp.Transport(rec, nil)}
+
+	resp, err := client.Get(s.URL + "/ok")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	resp, err = client.Get(s.URL + "/fail")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", s.URL+"/slow", nil)
+	_, err = client.Do(req)
+	assert.Error(t, err)
+
+	assert.Equal(t, 3.0, rec.Count("http.client.127_0_0_1.requests"))
+	assert.Equal(t, 1.0, rec.Count("http.client.127_0_0_1.status.2xx"))
+	assert.Equal(t, 1.0, rec.Count("http.client.127_0_0_1.status.5xx"))
+	assert.Equal(t, 1.0, rec.Count("http.client.127_0_0_1.errors.timeout"))
+	assert.Equal(t, 3, rec.Timings("http.client.127_0_0_1.latency"))
+	assert.Equal(t, 0.0, rec.Gauge("http.client.127_0_0_1.inflight"))
+}
+
+func TestTransportConnectError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new tcp listener failed: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	rec := statstest.NewRecorder()
+	client := &http.Client{Transport: statsdhttp.Transport(rec, nil, statsdhttp.Prefix("out"), statsdhttp.HostName(func(*http.Request) string {
+		return "dep"
+	}))}
+	_, err = client.Get("http://" + addr)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, rec.Count("out.client.dep.errors.connect"))
+}
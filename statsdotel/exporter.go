@@ -0,0 +1,210 @@
+// Package statsdotel provides an OpenTelemetry metric exporter which sends
+// data points to statsd.
+package statsdotel
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kirk91/statsd"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+type options struct {
+	resourceTags   []attribute.Key
+	resourcePrefix []attribute.Key
+}
+
+type Option func(*options)
+
+// ResourceTags adds the values of the given resource attributes as tags to
+// every metric.
+func ResourceTags(keys ...attribute.Key) Option {
+	return func(o *options) {
+		o.resourceTags = append(o.resourceTags, keys...)
+	}
+}
+
+// ResourcePrefix prepends the values of the given resource attributes as
+// bucket segments to every metric, e.g. "service.name".
+func ResourcePrefix(keys ...attribute.Key) Option {
+	return func(o *options) {
+		o.resourcePrefix = append(o.resourcePrefix, keys...)
+	}
+}
+
+// Exporter is a sdkmetric.Exporter which sends data points through a
+// statsd client. Data point attributes become tags. It asks the SDK for
+// delta temporality, except for up-down counters, and converts:
+//
+//   - monotonic sums to counters,
+//   - non-monotonic sums and gauges to gauges,
+//   - histograms to <name>.count, <name>.sum and <name>.bucket counters,
+//     the latter tagged with the bucket upper bound as le:<bound>, and
+//     <name>.min and <name>.max gauges.
+//
+// Exponential histograms are not supported and dropped.
+type Exporter struct {
+	c    statsd.Statter
+	opts options
+}
+
+var _ sdkmetric.Exporter = (*Exporter)(nil)
+
+func New(c statsd.Statter, opt ...Option) *Exporter {
+	e := &Exporter{c: c}
+	for _, f := range opt {
+		f(&e.opts)
+	}
+	return e
+}
+
+func (e *Exporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter:
+		return metricdata.CumulativeTemporality
+	}
+	return metricdata.DeltaTemporality
+}
+
+func (e *Exporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *Exporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	prefix, tags := e.resourceFields(rm.Resource)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			e.export(m, prefix, tags)
+		}
+	}
+	return ctx.Err()
+}
+
+// ForceFlush is a no-op, the client flushes on its own.
+func (e *Exporter) ForceFlush(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Shutdown is a no-op, the client is owned by the caller.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (e *Exporter) resourceFields(res *resource.Resource) ([]statsd.Field, []statsd.Field) {
+	var prefix, tags []statsd.Field
+	if res == nil {
+		return nil, nil
+	}
+	for _, k := range e.opts.resourcePrefix {
+		if v, ok := res.Set().Value(k); ok {
+			prefix = append(prefix, statsd.String(v.Emit()))
+		}
+	}
+	for _, k := range e.opts.resourceTags {
+		if v, ok := res.Set().Value(k); ok {
+			tags = append(tags, statsd.Tag(string(k), v.Emit()))
+		}
+	}
+	return prefix, tags
+}
+
+type point struct {
+	bucket []statsd.Field
+	n      int // length of bucket without suffix and attributes
+}
+
+func newPoint(prefix []statsd.Field, name string, tags []statsd.Field, attrs attribute.Set) *point {
+	bucket := make([]statsd.Field, 0, len(prefix)+2+len(tags)+attrs.Len()+1)
+	bucket = append(bucket, prefix...)
+	bucket = append(bucket, statsd.String(name))
+	p := &point{n: len(bucket)}
+	bucket = append(bucket, tags...)
+	for iter := attrs.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		bucket = append(bucket, statsd.Tag(string(kv.Key), kv.Value.Emit()))
+	}
+	p.bucket = bucket
+	return p
+}
+
+// with returns the bucket with suffix appended to the name and extra tags.
+func (p *point) with(suffix string, extra ...statsd.Field) []statsd.Field {
+	bucket := make([]statsd.Field, 0, len(p.bucket)+1+len(extra))
+	bucket = append(bucket, p.bucket[:p.n]...)
+	bucket = append(bucket, statsd.String(suffix))
+	bucket = append(bucket, p.bucket[p.n:]...)
+	return append(bucket, extra...)
+}
+
+func (e *Exporter) export(m metricdata.Metrics, prefix, tags []statsd.Field) {
+	switch data := m.Data.(type) {
+	case metricdata.Sum[int64]:
+		for _, dp := range data.DataPoints {
+			p := newPoint(prefix, m.Name, tags, dp.Attributes)
+			if data.IsMonotonic && data.Temporality == metricdata.DeltaTemporality {
+				e.c.CountInt64(dp.Value, p.bucket...)
+			} else {
+				e.c.GaugeInt64(dp.Value, p.bucket...)
+			}
+		}
+	case metricdata.Sum[float64]:
+		for _, dp := range data.DataPoints {
+			p := newPoint(prefix, m.Name, tags, dp.Attributes)
+			if data.IsMonotonic && data.Temporality == metricdata.DeltaTemporality {
+				e.c.CountFloat64(dp.Value, p.bucket...)
+			} else {
+				e.c.GaugeFloat64(dp.Value, p.bucket...)
+			}
+		}
+	case metricdata.Gauge[int64]:
+		for _, dp := range data.DataPoints {
+			e.c.GaugeInt64(dp.Value, newPoint(prefix, m.Name, tags, dp.Attributes).bucket...)
+		}
+	case metricdata.Gauge[float64]:
+		for _, dp := range data.DataPoints {
+			e.c.GaugeFloat64(dp.Value, newPoint(prefix, m.Name, tags, dp.Attributes).bucket...)
+		}
+	case metricdata.Histogram[int64]:
+		for _, dp := range data.DataPoints {
+			e.exportHistogram(newPoint(prefix, m.Name, tags, dp.Attributes), dp.Count, dp.Bounds, dp.BucketCounts,
+				float64(dp.Sum), toFloat64(dp.Min), toFloat64(dp.Max))
+		}
+	case metricdata.Histogram[float64]:
+		for _, dp := range data.DataPoints {
+			e.exportHistogram(newPoint(prefix, m.Name, tags, dp.Attributes), dp.Count, dp.Bounds, dp.BucketCounts,
+				dp.Sum, dp.Min, dp.Max)
+		}
+	}
+}
+
+func toFloat64(e metricdata.Extrema[int64]) metricdata.Extrema[float64] {
+	if v, ok := e.Value(); ok {
+		return metricdata.NewExtrema(float64(v))
+	}
+	return metricdata.Extrema[float64]{}
+}
+
+func (e *Exporter) exportHistogram(p *point, count uint64, bounds []float64, counts []uint64, sum float64, min, max metricdata.Extrema[float64]) {
+	e.c.CountUint64(count, p.with("count")...)
+	e.c.CountFloat64(sum, p.with("sum")...)
+	if v, ok := min.Value(); ok {
+		e.c.GaugeFloat64(v, p.with("min")...)
+	}
+	if v, ok := max.Value(); ok {
+		e.c.GaugeFloat64(v, p.with("max")...)
+	}
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'f', -1, 64)
+		}
+		e.c.CountUint64(n, p.with("bucket", statsd.Tag("le", le))...)
+	}
+}
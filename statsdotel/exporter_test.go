@@ -0,0 +1,60 @@
+package statsdotel_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kirk91/statsd"
+	"github.com/kirk91/statsd/statsdotel"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+func TestExporter(t *testing.T) {
+	a := statsd.NewAggregator()
+	c, _ := statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a))
+	exp := statsdotel.New(c, statsdotel.ResourcePrefix("service.name"), statsdotel.ResourceTags("env"))
+
+	res := resource.NewSchemaless(attribute.String("service.name", "api"), attribute.String("env", "prod"))
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp)),
+		sdkmetric.WithView(sdkmetric.NewView(
+			sdkmetric.Instrument{Name: "latency"},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: []float64{10, 100}}},
+		)),
+	)
+	defer mp.Shutdown(context.Background())
+	meter := mp.Meter("test")
+	ctx := context.Background()
+
+	requests, _ := meter.Int64Counter("requests")
+	requests.Add(ctx, 2, metric.WithAttributes(attribute.String("code", "200")))
+	requests.Add(ctx, 1, metric.WithAttributes(attribute.String("code", "200")))
+	cost, _ := meter.Float64Counter("cost")
+	cost.Add(ctx, 0.25)
+	cost.Add(ctx, 0.5)
+	inflight, _ := meter.Int64UpDownCounter("inflight")
+	inflight.Add(ctx, 3)
+	inflight.Add(ctx, -1)
+	latency, _ := meter.Float64Histogram("latency")
+	latency.Record(ctx, 5)
+	latency.Record(ctx, 50)
+	latency.Record(ctx, 70)
+	assert.NoError(t, mp.ForceFlush(ctx))
+
+	assert.Equal(t, []statsd.Aggregate{
+		{Name: "api.cost", Tags: []string{"env:prod"}, Type: statsd.MetricTypeCount, Value: 0.75},
+		{Name: "api.inflight", Tags: []string{"env:prod"}, Type: statsd.MetricTypeGauge, Value: 2},
+		{Name: "api.latency.bucket", Tags: []string{"env:prod", "le:10"}, Type: statsd.MetricTypeCount, Value: 1},
+		{Name: "api.latency.bucket", Tags: []string{"env:prod", "le:100"}, Type: statsd.MetricTypeCount, Value: 2},
+		{Name: "api.latency.count", Tags: []string{"env:prod"}, Type: statsd.MetricTypeCount, Value: 3},
+		{Name: "api.latency.max", Tags: []string{"env:prod"}, Type: statsd.MetricTypeGauge, Value: 70},
+		{Name: "api.latency.min", Tags: []string{"env:prod"}, Type: statsd.MetricTypeGauge, Value: 5},
+		{Name: "api.latency.sum", Tags: []string{"env:prod"}, Type: statsd.MetricTypeCount, Value: 125},
+		{Name: "api.requests", Tags: []string{"code:200", "env:prod"}, Type: statsd.MetricTypeCount, Value: 3},
+	}, a.Snapshot())
+}
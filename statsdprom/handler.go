@@ -0,0 +1,219 @@
+// Package statsdprom exposes client-side aggregates in the Prometheus text
+// exposition format.
+package statsdprom
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kirk91/statsd"
+)
+
+// Rule maps statsd names matching Match to a Prometheus metric. Match is a
+// dotted pattern in which "*" matches exactly one segment, e.g.
+// "http.*.requests". Name and label values may refer to the segments
+// matched by the n-th "*" as $n:
+//
+//	statsdprom.Rule{
+//		Match:  "http.*.requests",
+//		Name:   "http_requests_total",
+//		Labels: map[string]string{"route": "$1"},
+//	}
+type Rule struct {
+	Match  string
+	Name   string
+	Labels map[string]string
+}
+
+func (r *Rule) apply(segments []string) (string, map[string]string, bool) {
+	pattern := strings.Split(r.Match, ".")
+	if len(pattern) != len(segments) {
+		return "", nil, false
+	}
+	var captures []string
+	for i, p := range pattern {
+		switch p {
+		case "*":
+			captures = append(captures, segments[i])
+		case segments[i]:
+		default:
+			return "", nil, false
+		}
+	}
+
+	expand := func(s string) string {
+		for i := len(captures); i > 0; i-- {
+			s = strings.Replace(s, "$"+strconv.Itoa(i), captures[i-1], -1)
+		}
+		return s
+	}
+	labels := make(map[string]string, len(r.Labels))
+	for k, v := range r.Labels {
+		labels[k] = expand(v)
+	}
+	return expand(r.Name), labels, true
+}
+
+type options struct {
+	rules     []Rule
+	namespace string
+}
+
+type Option func(*options)
+
+// Rules adds mapping rules. The first matching rule is applied. Names not
+// matching any rule are used as they are with invalid characters replaced
+// by '_', e.g. "http.api.requests" becomes "http_api_requests".
+func Rules(rules ...Rule) Option {
+	return func(o *options) {
+		o.rules = append(o.rules, rules...)
+	}
+}
+
+// Namespace is prepended to every metric name, separated by '_'.
+func Namespace(s string) Option {
+	return func(o *options) {
+		o.namespace = s
+	}
+}
+
+type sample struct {
+	labels map[string]string
+	agg    statsd.Aggregate
+}
+
+type family struct {
+	typ     statsd.MetricType
+	samples []sample
+}
+
+// Handler serves the aggregates of a in the Prometheus text exposition
+// format. Counters are exposed as counters, gauges as gauges and timings as
+// summaries with _sum, in the TimingUnit of the clients, and _count. Tags are exposed as
+// labels, taking precedence over labels set by rules.
+func Handler(a *statsd.Aggregator, opt ...Option) http.Handler {
+	var o options
+	for _, f := range opt {
+		f(&o)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families := make(map[string]*family)
+		var names []string
+		for _, agg := range a.Snapshot() {
+			name, labels := o.mapName(agg.Name)
+			for _, tag := range agg.Tags {
+				k, v := tag, ""
+				if i := strings.IndexByte(tag, ':'); i >= 0 {
+					k, v = tag[:i], tag[i+1:]
+				}
+				labels[sanitize(k)] = v
+			}
+
+			f, ok := families[name]
+			if !ok {
+				f = &family{typ: agg.Type}
+				families[name] = f
+				names = append(names, name)
+			} else if f.typ != agg.Type {
+				continue
+			}
+			f.samples = append(f.samples, sample{labels: labels, agg: agg})
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, name := range names {
+			writeFamily(bw, name, families[name])
+		}
+		bw.Flush()
+	})
+}
+
+func (o *options) mapName(statsdName string) (string, map[string]string) {
+	segments := strings.Split(statsdName, ".")
+	name, labels := statsdName, map[string]string{}
+	for i := range o.rules {
+		if n, l, ok := o.rules[i].apply(segments); ok {
+			name, labels = n, l
+			break
+		}
+	}
+	if o.namespace != "" {
+		name = o.namespace + "_" + name
+	}
+	return sanitize(name), labels
+}
+
+func writeFamily(w *bufio.Writer, name string, f *family) {
+	typ := "gauge"
+	switch f.typ {
+	case statsd.MetricTypeCount:
+		typ = "counter"
+	case statsd.MetricTypeTiming:
+		typ = "summary"
+	}
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+
+	for _, s := range f.samples {
+		labels := formatLabels(s.labels)
+		if f.typ == statsd.MetricTypeTiming {
+			w.WriteString(name + "_sum" + labels + " " + formatFloat(s.agg.Value) + "\n")
+			w.WriteString(name + "_count" + labels + " " + strconv.FormatUint(s.agg.Count, 10) + "\n")
+			continue
+		}
+		w.WriteString(name + labels + " " + formatFloat(s.agg.Value) + "\n")
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(sanitize(k))
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueEscaper.Replace(labels[k]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sanitize replaces characters which are invalid in Prometheus metric and
+// label names by '_', and prepends '_' to names starting with a digit.
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, ch := range b {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '_', ch == ':':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
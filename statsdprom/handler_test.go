@@ -0,0 +1,46 @@
+package statsdprom_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd"
+	"github.com/kirk91/statsd/statsdprom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	a := statsd.NewAggregator()
+	c, err := statsd.New("udp", "", statsd.NoopFallback(), statsd.AggregateTo(a), statsd.Prefix("app"))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	c.Increment(statsd.String("http"), statsd.String("users"), statsd.String("requests"))
+	c.CountInt32(2, statsd.String("http"), statsd.String("users"), statsd.String("requests"))
+	c.Increment(statsd.String("http"), statsd.String("items"), statsd.String("requests"))
+	c.GaugeFloat64(1.5, statsd.String("queue.size"))
+	c.GaugeInt32(3, statsd.String("queue.size"))
+	c.Timing(10*time.Millisecond, statsd.String("db"), statsd.String("query"))
+	c.Timing(20*time.Millisecond, statsd.String("db"), statsd.String("query"))
+
+	h := statsdprom.Handler(a, statsdprom.Namespace("ns"), statsdprom.Rules(statsdprom.Rule{
+		Match:  "app.http.*.requests",
+		Name:   "http_requests_total",
+		Labels: map[string]string{"route": "$1"},
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+
+	assert.Equal(t, `# TYPE ns_app_db_query summary
+ns_app_db_query_sum 30
+ns_app_db_query_count 2
+# TYPE ns_app_queue_size gauge
+ns_app_queue_size 3
+# TYPE ns_http_requests_total counter
+ns_http_requests_total{route="items"} 1
+ns_http_requests_total{route="users"} 3
+`, string(body))
+}
@@ -0,0 +1,272 @@
+// Package statsdruntime periodically reports Go runtime metrics to statsd.
+package statsdruntime
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/kirk91/statsd"
+	"github.com/kirk91/statsd/internal/poll"
+)
+
+// DefaultMetrics are the runtime/metrics names collected unless the Metrics
+// option is given.
+var DefaultMetrics = []string{
+	"/sched/goroutines:goroutines",
+	"/sched/latencies:seconds",
+	"/sched/pauses/total/gc:seconds",
+	"/gc/cycles/total:gc-cycles",
+	"/gc/heap/goal:bytes",
+	"/gc/heap/objects:objects",
+	"/gc/heap/allocs:bytes",
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/total:bytes",
+}
+
+// fallbacks read metrics from runtime.MemStats when runtime/metrics does not
+// support them.
+var fallbacks = map[string]struct {
+	read       func(*runtime.MemStats) uint64
+	cumulative bool
+}{
+	"/sched/goroutines:goroutines":       {func(*runtime.MemStats) uint64 { return uint64(runtime.NumGoroutine()) }, false},
+	"/gc/cycles/total:gc-cycles":         {func(ms *runtime.MemStats) uint64 { return uint64(ms.NumGC) }, true},
+	"/gc/heap/goal:bytes":                {func(ms *runtime.MemStats) uint64 { return ms.NextGC }, false},
+	"/gc/heap/objects:objects":           {func(ms *runtime.MemStats) uint64 { return ms.HeapObjects }, false},
+	"/gc/heap/allocs:bytes":              {func(ms *runtime.MemStats) uint64 { return ms.TotalAlloc }, true},
+	"/memory/classes/heap/objects:bytes": {func(ms *runtime.MemStats) uint64 { return ms.HeapAlloc }, false},
+	"/memory/classes/total:bytes":        {func(ms *runtime.MemStats) uint64 { return ms.Sys }, false},
+}
+
+type options struct {
+	interval time.Duration
+	prefix   string
+	names    []string
+}
+
+type Option func(*options)
+
+// Interval sets how often metrics are collected, 10s by default.
+func Interval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// Prefix sets the first bucket segment of every metric, "runtime" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+// Metrics sets the runtime/metrics names to collect.
+func Metrics(names ...string) Option {
+	return func(o *options) {
+		o.names = names
+	}
+}
+
+type metric struct {
+	name       string
+	bucket     statsd.Field
+	cumulative bool
+	fallback   func(*runtime.MemStats) uint64
+
+	last     uint64
+	lastHist *metrics.Float64Histogram
+}
+
+// Collector reports runtime metrics on every interval until stopped.
+//
+// Bucket names are derived from the metric names, e.g.
+// "/gc/heap/goal:bytes" is reported as "<prefix>.gc.heap.goal". Cumulative
+// integer metrics are reported as counters of their increase since the
+// previous collection, other numeric metrics as gauges. Histograms such as
+// scheduler latencies are reported as <name>.p50, .p90, .p99 and .max
+// gauges over the values observed during the interval, in milliseconds for
+// metrics measured in seconds.
+type Collector struct {
+	c      statsd.Statter
+	prefix statsd.Field
+
+	metrics  []*metric
+	samples  []metrics.Sample
+	memStats bool
+
+	loop *poll.Loop
+}
+
+// NewCollector creates a Collector and starts collecting in the background.
+func NewCollector(c statsd.Statter, opt ...Option) *Collector {
+	o := options{
+		interval: 10 * time.Second,
+		prefix:   "runtime",
+		names:    DefaultMetrics,
+	}
+	for _, f := range opt {
+		f(&o)
+	}
+
+	descs := make(map[string]metrics.Description)
+	for _, d := range metrics.All() {
+		descs[d.Name] = d
+	}
+
+	col := &Collector{
+		c:      c,
+		prefix: statsd.String(o.prefix),
+	}
+	for _, name := range o.names {
+		m := &metric{name: name, bucket: statsd.String(bucketName(name))}
+		if d, ok := descs[name]; ok {
+			m.cumulative = d.Cumulative
+			col.samples = append(col.samples, metrics.Sample{Name: name})
+		} else if f, ok := fallbacks[name]; ok {
+			m.fallback = f.read
+			m.cumulative = f.cumulative
+			col.memStats = true
+		} else {
+			continue
+		}
+		col.metrics = append(col.metrics, m)
+	}
+	col.prime()
+
+	col.loop = poll.Start(o.interval, col.collect)
+	return col
+}
+
+// bucketName turns "/gc/heap/goal:bytes" into "gc.heap.goal".
+func bucketName(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[:i]
+	}
+	return strings.Replace(strings.Trim(name, "/"), "/", ".", -1)
+}
+
+// Stop stops collecting and waits for an in-progress collection to finish.
+func (col *Collector) Stop() {
+	col.loop.Stop()
+}
+
+// prime reads the baselines of cumulative metrics, so the first collection
+// reports their increase over the interval rather than since process start.
+func (col *Collector) prime() {
+	metrics.Read(col.samples)
+	var ms runtime.MemStats
+	if col.memStats {
+		runtime.ReadMemStats(&ms)
+	}
+
+	i := 0
+	for _, m := range col.metrics {
+		if m.fallback != nil {
+			m.last = m.fallback(&ms)
+			continue
+		}
+
+		v := col.samples[i].Value
+		i++
+		switch v.Kind() {
+		case metrics.KindUint64:
+			m.last = v.Uint64()
+		case metrics.KindFloat64Histogram:
+			h := v.Float64Histogram()
+			m.lastHist = &metrics.Float64Histogram{Counts: append([]uint64(nil), h.Counts...), Buckets: h.Buckets}
+		}
+	}
+}
+
+func (col *Collector) collect() {
+	metrics.Read(col.samples)
+	var ms runtime.MemStats
+	if col.memStats {
+		runtime.ReadMemStats(&ms)
+	}
+
+	i := 0
+	for _, m := range col.metrics {
+		if m.fallback != nil {
+			col.reportUint64(m, m.fallback(&ms))
+			continue
+		}
+
+		v := col.samples[i].Value
+		i++
+		switch v.Kind() {
+		case metrics.KindUint64:
+			col.reportUint64(m, v.Uint64())
+		case metrics.KindFloat64:
+			col.c.GaugeFloat64(v.Float64(), col.prefix, m.bucket)
+		case metrics.KindFloat64Histogram:
+			col.reportHistogram(m, v.Float64Histogram())
+		}
+	}
+}
+
+func (col *Collector) reportUint64(m *metric, v uint64) {
+	if !m.cumulative {
+		col.c.GaugeUint64(v, col.prefix, m.bucket)
+		return
+	}
+	if v >= m.last {
+		col.c.CountUint64(v-m.last, col.prefix, m.bucket)
+	}
+	m.last = v
+}
+
+var quantiles = []struct {
+	q      float64
+	suffix statsd.Field
+}{
+	{0.5, statsd.String("p50")},
+	{0.9, statsd.String("p90")},
+	{0.99, statsd.String("p99")},
+	{1, statsd.String("max")},
+}
+
+func (col *Collector) reportHistogram(m *metric, h *metrics.Float64Histogram) {
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	var total uint64
+	for i := range counts {
+		if m.lastHist != nil && len(m.lastHist.Counts) == len(counts) {
+			counts[i] -= m.lastHist.Counts[i]
+		}
+		total += counts[i]
+	}
+	m.lastHist = &metrics.Float64Histogram{Counts: append([]uint64(nil), h.Counts...), Buckets: h.Buckets}
+	if total == 0 {
+		return
+	}
+
+	scale := 1.0
+	if strings.HasSuffix(m.name, ":seconds") {
+		scale = 1000
+	}
+	for _, q := range quantiles {
+		v := histogramQuantile(counts, h.Buckets, total, q.q)
+		col.c.GaugeFloat64(v*scale, col.prefix, m.bucket, q.suffix)
+	}
+}
+
+// histogramQuantile returns the upper bound of the bucket containing the
+// q-quantile, or its lower bound if the upper one is infinite.
+func histogramQuantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, n := range counts {
+		seen += n
+		if seen >= rank && n > 0 {
+			if hi := buckets[i+1]; !math.IsInf(hi, 1) {
+				return hi
+			}
+			return buckets[i]
+		}
+	}
+	return buckets[len(buckets)-1]
+}
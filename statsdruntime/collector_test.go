@@ -0,0 +1,64 @@
+package statsdruntime_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdruntime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	rec := statstest.NewRecorder()
+	col := statsdruntime.NewCollector(rec, statsdruntime.Interval(10*time.Millisecond))
+	runtime.GC()
+	time.Sleep(50 * time.Millisecond)
+	col.Stop()
+	col.Stop()
+
+	assert.NotZero(t, rec.Calls("runtime.sched.goroutines"))
+	assert.NotZero(t, rec.Calls("runtime.gc.cycles.total"))
+	assert.NotZero(t, rec.Calls("runtime.memory.classes.total"))
+	assert.NotZero(t, rec.Calls("runtime.sched.pauses.total.gc.max"))
+
+	n := rec.Calls("runtime.sched.goroutines")
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, rec.Calls("runtime.sched.goroutines"))
+}
+
+func TestCollectorOptions(t *testing.T) {
+	rec := statstest.NewRecorder()
+	col := statsdruntime.NewCollector(rec,
+		statsdruntime.Interval(10*time.Millisecond),
+		statsdruntime.Prefix("rt"),
+		statsdruntime.Metrics("/gc/heap/goal:bytes", "/not/a/metric:bytes"))
+	time.Sleep(50 * time.Millisecond)
+	col.Stop()
+
+	assert.NotZero(t, rec.Calls("rt.gc.heap.goal"))
+	assert.Zero(t, rec.Calls("rt.sched.goroutines"))
+	assert.Zero(t, rec.Calls("rt.not.a.metric"))
+}
+
+func TestCollectorBaseline(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	rec := statstest.NewRecorder()
+	col := statsdruntime.NewCollector(rec,
+		statsdruntime.Interval(10*time.Millisecond),
+		statsdruntime.Metrics("/gc/cycles/total:gc-cycles"))
+	runtime.GC()
+	time.Sleep(50 * time.Millisecond)
+	col.Stop()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	assert.NotZero(t, rec.Count("runtime.gc.cycles.total"))
+	assert.LessOrEqual(t, rec.Count("runtime.gc.cycles.total"), float64(after.NumGC-before.NumGC))
+}
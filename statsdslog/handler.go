@@ -0,0 +1,130 @@
+// Package statsdslog counts log records per level in statsd before passing
+// them on to another slog.Handler.
+package statsdslog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/kirk91/statsd"
+)
+
+type options struct {
+	prefix  string
+	attrKey string
+}
+
+type Option func(*options)
+
+// Prefix sets the first bucket segment of every metric, "log" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+// Attr additionally counts records per value of the top-level attribute
+// key, e.g. "component".
+func Attr(key string) Option {
+	return func(o *options) {
+		o.attrKey = key
+	}
+}
+
+// Handler increments
+//
+//	<prefix>.<level>
+//	<prefix>.<attr key>.<attr value>.<level>  if the Attr option is set
+//
+// for every handled record, then delegates to the wrapped handler. Levels
+// are lower-cased, e.g. "error" or "info_2" for slog.LevelInfo+2. In
+// attribute values, anything but letters, digits, '_' and '-' is replaced
+// by an underscore, so "db.pool" counts as "db_pool".
+type Handler struct {
+	next   slog.Handler
+	c      statsd.Statter
+	prefix statsd.Field
+	opts   options
+
+	grouped bool
+	bound   string
+}
+
+// NewHandler returns a Handler counting records handled by next.
+func NewHandler(c statsd.Statter, next slog.Handler, opt ...Option) *Handler {
+	o := options{prefix: "log"}
+	for _, f := range opt {
+		f(&o)
+	}
+	return &Handler{
+		next:   next,
+		c:      c,
+		prefix: statsd.String(o.prefix),
+		opts:   o,
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	level := statsd.String(levelName(r.Level))
+	h.c.Increment(h.prefix, level)
+
+	if h.opts.attrKey != "" {
+		value := h.bound
+		if !h.grouped {
+			r.Attrs(func(a slog.Attr) bool {
+				if a.Key == h.opts.attrKey {
+					value = attrValue(a)
+					return false
+				}
+				return true
+			})
+		}
+		if value != "" {
+			h.c.Increment(h.prefix, statsd.String(h.opts.attrKey), statsd.String(value), level)
+		}
+	}
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.next = h.next.WithAttrs(attrs)
+	if h.opts.attrKey != "" && !h.grouped {
+		for _, a := range attrs {
+			if a.Key == h.opts.attrKey {
+				h2.bound = attrValue(a)
+			}
+		}
+	}
+	return &h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.next = h.next.WithGroup(name)
+	h2.grouped = true
+	return &h2
+}
+
+func levelName(l slog.Level) string {
+	return strings.Replace(strings.ToLower(l.String()), "+", "_", 1)
+}
+
+func attrValue(a slog.Attr) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, a.Value.Resolve().String())
+}
@@ -0,0 +1,47 @@
+package statsdslog_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	var out bytes.Buffer
+	rec := statstest.NewRecorder()
+	next := slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := slog.New(statsdslog.NewHandler(rec, next, statsdslog.Attr("component")))
+
+	logger.Debug("dropped")
+	logger.Info("hello")
+	logger.Error("failed", "component", "db.pool")
+	logger.Error("failed", "component", "db:primary|x@1")
+	logger.With("component", "api").Warn("slow")
+	logger.With("component", "api").WithGroup("req").Error("failed", "component", "ignored")
+	logger.Log(context.Background(), slog.LevelInfo+2, "custom")
+
+	assert.Equal(t, map[string]float64{
+		"log.info":                           1,
+		"log.info_2":                         1,
+		"log.warn":                           1,
+		"log.error":                          3,
+		"log.component.db_pool.error":        1,
+		"log.component.db_primary_x_1.error": 1,
+		"log.component.api.warn":             1,
+		"log.component.api.error":            1,
+	}, rec.Counts())
+	assert.Equal(t, 6, bytes.Count(out.Bytes(), []byte("\n")))
+}
+
+func TestHandlerPrefix(t *testing.T) {
+	var out bytes.Buffer
+	rec := statstest.NewRecorder()
+	logger := slog.New(statsdslog.NewHandler(rec, slog.NewJSONHandler(&out, nil), statsdslog.Prefix("logs")))
+	logger.Info("hello", "component", "api")
+	assert.Equal(t, map[string]float64{"logs.info": 1}, rec.Counts())
+}
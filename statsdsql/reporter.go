@@ -0,0 +1,128 @@
+// Package statsdsql periodically reports database/sql connection pool
+// statistics to statsd.
+package statsdsql
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/kirk91/statsd"
+	"github.com/kirk91/statsd/internal/poll"
+)
+
+type options struct {
+	interval time.Duration
+	prefix   string
+}
+
+type Option func(*options)
+
+// Interval sets how often pools are sampled, 10s by default.
+func Interval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// Prefix sets the first bucket segment of every metric, "sql" by default.
+func Prefix(s string) Option {
+	return func(o *options) {
+		o.prefix = s
+	}
+}
+
+type pool struct {
+	name statsd.Field
+	db   *sql.DB
+	last sql.DBStats
+}
+
+// Reporter samples sql.DB.Stats of the added handles on every interval and
+// reports, per handle name:
+//
+//	<prefix>.<name>.connections.max_open         gauge
+//	<prefix>.<name>.connections.open             gauge
+//	<prefix>.<name>.connections.in_use           gauge
+//	<prefix>.<name>.connections.idle             gauge
+//	<prefix>.<name>.wait_count                   count
+//	<prefix>.<name>.wait_duration                timing
+//	<prefix>.<name>.closed.max_idle              count
+//	<prefix>.<name>.closed.max_idle_time         count
+//	<prefix>.<name>.closed.max_lifetime          count
+//
+// Counts and wait_duration are increases since the previous sample.
+type Reporter struct {
+	c      statsd.Statter
+	prefix statsd.Field
+
+	mu    sync.Mutex
+	pools map[string]*pool
+
+	loop *poll.Loop
+}
+
+// NewReporter creates a Reporter and starts sampling in the background.
+func NewReporter(c statsd.Statter, opt ...Option) *Reporter {
+	o := options{
+		interval: 10 * time.Second,
+		prefix:   "sql",
+	}
+	for _, f := range opt {
+		f(&o)
+	}
+
+	r := &Reporter{
+		c:      c,
+		prefix: statsd.String(o.prefix),
+		pools:  make(map[string]*pool),
+	}
+	r.loop = poll.Start(o.interval, r.report)
+	return r
+}
+
+// Add starts reporting db under name, replacing any handle previously added
+// under the same name.
+func (r *Reporter) Add(name string, db *sql.DB) {
+	r.mu.Lock()
+	r.pools[name] = &pool{name: statsd.String(name), db: db, last: db.Stats()}
+	r.mu.Unlock()
+}
+
+// Remove stops reporting the handle added under name.
+func (r *Reporter) Remove(name string) {
+	r.mu.Lock()
+	delete(r.pools, name)
+	r.mu.Unlock()
+}
+
+// Stop stops sampling and waits for an in-progress sample to finish.
+func (r *Reporter) Stop() {
+	r.loop.Stop()
+}
+
+var (
+	connections = statsd.String("connections")
+	closed      = statsd.String("closed")
+)
+
+func (r *Reporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.pools {
+		s := p.db.Stats()
+		last := p.last
+		p.last = s
+
+		r.c.GaugeInt64(int64(s.MaxOpenConnections), r.prefix, p.name, connections, statsd.String("max_open"))
+		r.c.GaugeInt64(int64(s.OpenConnections), r.prefix, p.name, connections, statsd.String("open"))
+		r.c.GaugeInt64(int64(s.InUse), r.prefix, p.name, connections, statsd.String("in_use"))
+		r.c.GaugeInt64(int64(s.Idle), r.prefix, p.name, connections, statsd.String("idle"))
+
+		r.c.CountInt64(s.WaitCount-last.WaitCount, r.prefix, p.name, statsd.String("wait_count"))
+		r.c.Timing(s.WaitDuration-last.WaitDuration, r.prefix, p.name, statsd.String("wait_duration"))
+		r.c.CountInt64(s.MaxIdleClosed-last.MaxIdleClosed, r.prefix, p.name, closed, statsd.String("max_idle"))
+		r.c.CountInt64(s.MaxIdleTimeClosed-last.MaxIdleTimeClosed, r.prefix, p.name, closed, statsd.String("max_idle_time"))
+		r.c.CountInt64(s.MaxLifetimeClosed-last.MaxLifetimeClosed, r.prefix, p.name, closed, statsd.String("max_lifetime"))
+	}
+}
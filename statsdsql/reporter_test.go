@@ -0,0 +1,58 @@
+package statsdsql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kirk91/statsd/internal/statstest"
+	"github.com/kirk91/statsd/statsdsql"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("statsdsql-fake", fakeDriver{})
+}
+
+func TestReporter(t *testing.T) {
+	db, err := sql.Open("statsdsql-fake", "")
+	if err != nil {
+		t.Fatalf("open db failed: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	rec := statstest.NewRecorder()
+	r := statsdsql.NewReporter(rec, statsdsql.Interval(10*time.Millisecond))
+	r.Add("main", db)
+
+	conn, err := db.Conn(context.Background())
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	_, err = db.Conn(ctx) // waits for the single connection and times out
+	cancel()
+	assert.Error(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	r.Stop()
+	conn.Close()
+
+	assert.Equal(t, 1.0, rec.Gauge("sql.main.connections.max_open"))
+	assert.Equal(t, 1.0, rec.Gauge("sql.main.connections.open"))
+	assert.Equal(t, 1.0, rec.Gauge("sql.main.connections.in_use"))
+	assert.Equal(t, 0.0, rec.Gauge("sql.main.connections.idle"))
+	assert.Equal(t, 1.0, rec.Count("sql.main.wait_count"))
+}
@@ -0,0 +1,178 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+type Quantile struct {
+	Q      float64
+	Suffix string
+}
+
+// Summary configures the gauges sent for each timing by TimingSummary. A
+// statistic with an empty suffix is not sent.
+type Summary struct {
+	Count     string
+	Sum       string
+	Min       string
+	Max       string
+	Quantiles []Quantile
+}
+
+var DefaultSummary = Summary{
+	Count: "count",
+	Sum:   "sum",
+	Min:   "min",
+	Max:   "max",
+	Quantiles: []Quantile{
+		{0.5, "p50"},
+		{0.9, "p90"},
+		{0.99, "p99"},
+	},
+}
+
+// TimingSummary makes the client aggregate timings over each flush period
+// instead of sending every one of them. At the end of the period a gauge
+// named <timing name>.<suffix> is sent for every statistic configured in s.
+// Quantiles are estimated within 1% relative error.
+func TimingSummary(s Summary) Option {
+	return func(o *options) {
+		o.summary = &s
+	}
+}
+
+type timingSummary struct {
+	name     string
+	tags     []Field
+	count    uint64
+	sum      float64
+	min, max float64
+	sketch   sketch
+}
+
+type timingSummaries struct {
+	cfg Summary
+
+	mu sync.Mutex
+	m  map[string]*timingSummary // keyed by name and tags
+}
+
+func newTimingSummaries(cfg Summary) *timingSummaries {
+	return &timingSummaries{cfg: cfg, m: make(map[string]*timingSummary)}
+}
+
+func (ts *timingSummaries) add(m *Metric, v float64) {
+	b := getBuf()
+	b.bs = append(b.bs, m.Name...)
+	appendTags(b, m.Tags, nil)
+
+	ts.mu.Lock()
+	s, ok := ts.m[string(b.bs)]
+	if !ok {
+		s = &timingSummary{
+			name: string(m.Name),
+			tags: append([]Field(nil), m.Tags...),
+			min:  v,
+			max:  v,
+		}
+		ts.m[string(b.bs)] = s
+	}
+	s.count++
+	s.sum += v
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+	s.sketch.add(v)
+	ts.mu.Unlock()
+
+	freeBuf(b)
+}
+
+// flush sends the summaries of the ending period through c.
+func (ts *timingSummaries) flush(c *Client) {
+	ts.mu.Lock()
+	m := ts.m
+	ts.m = make(map[string]*timingSummary, len(m))
+	ts.mu.Unlock()
+
+	for _, s := range m {
+		sendGauge := func(suffix string, v float64) {
+			if suffix == "" {
+				return
+			}
+			b := getBuf()
+			b.scratch = append(b.scratch, s.name...)
+			b.scratch = append(b.scratch, '.')
+			b.scratch = append(b.scratch, suffix...)
+			nameLen := len(b.scratch)
+			b.scratch = appendFloat(b.scratch, v, c.opts.timingPrecision, 64)
+			b.m.Name = b.scratch[:nameLen]
+			b.m.Value = b.scratch[nameLen:]
+			b.m.Type = MetricTypeGauge
+			b.m.Tags = append(b.m.Tags, s.tags...)
+			c.format(b)
+			c.send(b)
+		}
+		sendGauge(ts.cfg.Count, float64(s.count))
+		sendGauge(ts.cfg.Sum, s.sum)
+		sendGauge(ts.cfg.Min, s.min)
+		sendGauge(ts.cfg.Max, s.max)
+		for _, q := range ts.cfg.Quantiles {
+			sendGauge(q.Suffix, s.sketch.quantile(q.Q))
+		}
+	}
+}
+
+const sketchRelativeAccuracy = 0.01
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// sketch is a mergeable quantile sketch in the manner of DDSketch: values
+// are counted in logarithmically sized bins, so that any quantile is
+// estimated within sketchRelativeAccuracy of its true value.
+type sketch struct {
+	bins  map[int]uint64
+	zeros uint64
+	count uint64
+}
+
+func (s *sketch) add(v float64) {
+	s.count++
+	if v <= 1e-9 {
+		s.zeros++
+		return
+	}
+	if s.bins == nil {
+		s.bins = make(map[int]uint64)
+	}
+	s.bins[int(math.Ceil(math.Log(v)/sketchLogGamma))]++
+}
+
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+
+	keys := make([]int, 0, len(s.bins))
+	for k := range s.bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	seen := s.zeros
+	for _, k := range keys {
+		seen += s.bins[k]
+		if seen > rank {
+			return 2 * math.Pow(sketchGamma, float64(k)) / (sketchGamma + 1)
+		}
+	}
+	return 2 * math.Pow(sketchGamma, float64(keys[len(keys)-1])) / (sketchGamma + 1)
+}